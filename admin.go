@@ -0,0 +1,112 @@
+package bort
+
+import (
+	"fmt"
+	"strings"
+)
+
+const pluginUsage = "usage: plugin list [channel] | plugin enable|disable <plugin> [channel|*]"
+
+// core admin commands
+func init() {
+	RegisterCommand("reload", "reload the configuration (admins only)", reload)
+	RegisterCommand("plugin", "list, enable, or disable plugins (admins only)", pluginCmd)
+	RegisterCommand("jobs", "list scheduled jobs (admins only)", jobsCmd)
+}
+
+// deny replies that the sender isn't allowed to use an admin command.
+func deny(in, out *Message) error {
+	out.Type = PrivMsg
+	out.Context = in.Nick
+	out.Text = in.Command + ": permission denied"
+	return nil
+}
+
+// reload reloads the configuration.
+func reload(in, out *Message) error {
+	if !IsAdmin(in) {
+		return deny(in, out)
+	}
+	if err := ReloadConfig(); err != nil {
+		return err
+	}
+	out.Type = PrivMsg
+	out.Text = "configuration reloaded"
+	return nil
+}
+
+// pluginCmd lists, enables, or disables plugins in a channel.  The channel
+// defaults to the one the command was given in, or all channels ("*") for
+// private messages.
+func pluginCmd(in, out *Message) error {
+	if !IsAdmin(in) {
+		return deny(in, out)
+	}
+	out.Type = PrivMsg
+	args := strings.Fields(in.Args)
+	channel := allChannels
+	if in.Context != in.Nick {
+		channel = in.Context
+	}
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "list":
+		if len(args) == 2 {
+			channel = args[1]
+		}
+		out.Text = listPlugins(channel)
+	case len(args) >= 2 && len(args) <= 3 && (args[0] == "enable" || args[0] == "disable"):
+		plugin := args[1]
+		if len(args) == 3 {
+			channel = args[2]
+		}
+		known := false
+		for _, name := range pluginNames() {
+			known = known || strings.EqualFold(name, plugin)
+		}
+		if !known {
+			return fmt.Errorf("%s: unknown plugin", plugin)
+		}
+		if err := setPluginState(plugin, channel, args[0] == "enable"); err != nil {
+			return err
+		}
+		out.Text = fmt.Sprintf("%s %sd in %s", plugin, args[0], channelDesc(channel))
+	default:
+		out.Text = pluginUsage
+	}
+	return nil
+}
+
+// jobsCmd lists the scheduled jobs.
+func jobsCmd(in, out *Message) error {
+	if !IsAdmin(in) {
+		return deny(in, out)
+	}
+	out.Type = PrivMsg
+	out.Context = in.Nick
+	out.Text = "no scheduled jobs"
+	if list := jobList(); len(list) > 0 {
+		out.Text = strings.Join(list, "\n")
+	}
+	return nil
+}
+
+// listPlugins describes the plugins and whether they're enabled in channel.
+func listPlugins(channel string) string {
+	plugins := channelPlugins(channel)
+	descs := []string{}
+	for _, name := range pluginNames() {
+		if !pluginEnabled(plugins, name) {
+			name += " (disabled)"
+		}
+		descs = append(descs, name)
+	}
+	return fmt.Sprintf("plugins in %s: %s", channelDesc(channel), strings.Join(descs, ", "))
+}
+
+// channelDesc describes a channel for replies.
+func channelDesc(channel string) string {
+	if channel == allChannels {
+		return "all channels"
+	}
+	return channel
+}
@@ -0,0 +1,312 @@
+package bort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ArgType is the type of a command argument.
+type ArgType int
+
+// argument types
+const (
+	StringArg ArgType = iota
+	IntArg
+	FloatArg
+	BoolArg // flags only, set by their presence
+	RestArg // last positional argument only, the rest of the arguments
+)
+
+// Arg declares a command argument.  Flags are given as --Name=value, --Name
+// value, -Short value, or, for BoolArg, just --Name or -Short.  Other
+// arguments are positional, in the order declared, and required unless
+// Optional.  Arguments may be quoted with ' or ", and a backslash escapes the
+// next character.  "--" ends the flags.  If a RestArg is the only argument
+// declared, it is the raw argument text, without quote or flag processing, so
+// text such as "O'Fallon, MO" or "-33.9,151.2" is taken as is.
+type Arg struct {
+	Name     string
+	Short    string // one letter flag name, optional
+	Type     ArgType
+	Flag     bool
+	Optional bool // for positional arguments
+}
+
+// Args holds parsed command arguments.
+type Args struct {
+	vals map[string]interface{}
+}
+
+// ArgsHandleFunc handles a command with parsed arguments (see
+// ContextHandleFunc).
+type ArgsHandleFunc func(ctx context.Context, in, out *Message, args *Args) error
+
+// Has reports whether the named argument was given.
+func (a *Args) Has(name string) bool {
+	_, ok := a.vals[name]
+	return ok
+}
+
+// String returns the named argument, or "" if not given.
+func (a *Args) String(name string) string {
+	s, _ := a.vals[name].(string)
+	return s
+}
+
+// Int returns the named IntArg argument, or 0 if not given.
+func (a *Args) Int(name string) int {
+	i, _ := a.vals[name].(int)
+	return i
+}
+
+// Float returns the named FloatArg argument, or 0 if not given.
+func (a *Args) Float(name string) float64 {
+	f, _ := a.vals[name].(float64)
+	return f
+}
+
+// Bool returns whether the named BoolArg flag was given.
+func (a *Args) Bool(name string) bool {
+	b, _ := a.vals[name].(bool)
+	return b
+}
+
+// RegisterCommandArgs registers a command handler, like RegisterCommand, that
+// is passed arguments parsed according to spec.  If the arguments are invalid,
+// the handler isn't called, and the error and usage are replied instead.
+func RegisterCommandArgs(cmd, help string, spec []Arg, handle ArgsHandleFunc) error {
+	return registerCommand(callerPlugin(), cmd, help, withArgs(cmd, spec, handle))
+}
+
+// withArgs adapts an ArgsHandleFunc to a ContextHandleFunc, replying with the
+// usage if the arguments are invalid.
+func withArgs(cmd string, spec []Arg, handle ArgsHandleFunc) ContextHandleFunc {
+	return func(ctx context.Context, in, out *Message) error {
+		args, err := ParseArgs(spec, in.Args)
+		if err != nil {
+			out.Type = PrivMsg
+			out.Text = fmt.Sprintf("%s: %s\n%s", cmd, err, Usage(cmd, spec))
+			return nil
+		}
+		return handle(ctx, in, out, args)
+	}
+}
+
+// Usage returns a usage message for a command with arguments declared by spec,
+// such as "usage: cmd [-v|--verbose] [--count=N] <name> [rest...]".
+func Usage(cmd string, spec []Arg) string {
+	parts := []string{"usage:", cmd}
+	for _, arg := range spec {
+		if arg.Flag {
+			name := "--" + arg.Name
+			if arg.Short != "" {
+				name = "-" + arg.Short + "|" + name
+			}
+			if arg.Type != BoolArg {
+				name += "=" + argPlaceholder(arg.Type)
+			}
+			parts = append(parts, "["+name+"]")
+		}
+	}
+	for _, arg := range spec {
+		if arg.Flag {
+			continue
+		}
+		name := arg.Name
+		if arg.Type == RestArg {
+			name += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+name+"]")
+		} else {
+			parts = append(parts, "<"+name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func argPlaceholder(typ ArgType) string {
+	switch typ {
+	case IntArg:
+		return "N"
+	case FloatArg:
+		return "X"
+	}
+	return "VALUE"
+}
+
+// ParseArgs parses command arguments according to spec.
+func ParseArgs(spec []Arg, text string) (*Args, error) {
+	if len(spec) == 1 && spec[0].Type == RestArg && !spec[0].Flag {
+		return rawArgs(spec[0], text)
+	}
+	tokens, err := SplitArgs(text)
+	if err != nil {
+		return nil, err
+	}
+	args := &Args{vals: map[string]interface{}{}}
+	positional := []string{}
+	flagsDone := false
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if flagsDone || !isFlag(tok) {
+			positional = append(positional, tok)
+			continue
+		}
+		if tok == "--" {
+			flagsDone = true
+			continue
+		}
+		name, val, hasVal := strings.Cut(strings.TrimLeft(tok, "-"), "=")
+		flag, ok := findFlag(spec, name, !strings.HasPrefix(tok, "--"))
+		if !ok {
+			return nil, fmt.Errorf("unknown flag %s", tok)
+		}
+		if flag.Type == BoolArg {
+			if hasVal {
+				return nil, fmt.Errorf("flag %s takes no value", tok)
+			}
+			args.vals[flag.Name] = true
+			continue
+		}
+		if !hasVal {
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("flag %s needs a value", tok)
+			}
+			i++
+			val = tokens[i]
+		}
+		if err := args.set(flag, val); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, arg := range spec {
+		if arg.Flag {
+			continue
+		}
+		if len(positional) == 0 {
+			if !arg.Optional {
+				return nil, fmt.Errorf("missing %s", arg.Name)
+			}
+			break
+		}
+		if arg.Type == RestArg {
+			args.vals[arg.Name] = strings.Join(positional, " ")
+			positional = nil
+			break
+		}
+		if err := args.set(arg, positional[0]); err != nil {
+			return nil, err
+		}
+		positional = positional[1:]
+	}
+	if len(positional) > 0 {
+		return nil, fmt.Errorf("unexpected %s", positional[0])
+	}
+	return args, nil
+}
+
+// rawArgs sets the RestArg arg to text, as is apart from surrounding space.
+func rawArgs(arg Arg, text string) (*Args, error) {
+	args := &Args{vals: map[string]interface{}{}}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		if !arg.Optional {
+			return nil, fmt.Errorf("missing %s", arg.Name)
+		}
+		return args, nil
+	}
+	args.vals[arg.Name] = text
+	return args, nil
+}
+
+// set converts val to the argument's type and sets it.
+func (a *Args) set(arg Arg, val string) error {
+	switch arg.Type {
+	case IntArg:
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", arg.Name)
+		}
+		a.vals[arg.Name] = i
+	case FloatArg:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", arg.Name)
+		}
+		a.vals[arg.Name] = f
+	default:
+		a.vals[arg.Name] = val
+	}
+	return nil
+}
+
+// isFlag reports whether tok is a flag, rather than a positional argument
+// such as "-" or a negative number.
+func isFlag(tok string) bool {
+	if len(tok) < 2 || tok[0] != '-' {
+		return false
+	}
+	_, err := strconv.ParseFloat(tok, 64)
+	return err != nil
+}
+
+// findFlag finds the flag declared by spec with the given name, or short name.
+func findFlag(spec []Arg, name string, short bool) (Arg, bool) {
+	for _, arg := range spec {
+		if arg.Flag && (short && arg.Short == name || !short && arg.Name == name) {
+			return arg, true
+		}
+	}
+	return Arg{}, false
+}
+
+// SplitArgs splits text into whitespace separated words, honoring quotes and
+// backslash escapes.
+func SplitArgs(text string) ([]string, error) {
+	words := []string{}
+	word := &strings.Builder{}
+	inWord, escaped := false, false
+	var quote rune
+	for _, r := range text {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inWord, escaped = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			inWord, quote = true, r
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			inWord = true
+			word.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
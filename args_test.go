@@ -0,0 +1,113 @@
+package bort_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := map[string][]string{
+		``:                        {},
+		`  a  b `:                 {"a", "b"},
+		`"a b" 'c "d"' e\ f`:      {"a b", `c "d"`, "e f"},
+		`x"y z"w ""`:              {"xy zw", ""},
+		`it\'s`:                   {"it's"},
+		`--name="New York" -v -5`: {"--name=New York", "-v", "-5"},
+	}
+	for text, want := range tests {
+		if got, err := bort.SplitArgs(text); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, %v, want %q", text, got, err, want)
+		}
+	}
+	for _, text := range []string{`"open`, `trailing\`} {
+		if _, err := bort.SplitArgs(text); err == nil {
+			t.Errorf("%s: expected error", text)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	spec := []bort.Arg{
+		{Name: "verbose", Short: "v", Type: bort.BoolArg, Flag: true},
+		{Name: "count", Short: "n", Type: bort.IntArg, Flag: true},
+		{Name: "scale", Type: bort.FloatArg},
+		{Name: "rest", Type: bort.RestArg, Optional: true},
+	}
+	args, err := bort.ParseArgs(spec, `-v 1.5 --count=3 "a b" -- -n c`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.Bool("verbose") || args.Int("count") != 3 || args.Float("scale") != 1.5 || args.String("rest") != "a b -n c" {
+		t.Errorf("got %+v", args)
+	}
+	if args, _ := bort.ParseArgs(spec, "-n 2 -2"); args.Bool("verbose") || args.Has("rest") || args.Float("scale") != -2 {
+		t.Errorf("got %+v", args)
+	}
+
+	errs := map[string]string{
+		"":            "missing scale",
+		"x":           "scale must be a number",
+		"1 -q":        "unknown flag -q",
+		"1 --count":   "flag --count needs a value",
+		"1 -n x":      "count must be an integer",
+		"1 -v=true":   "flag -v=true takes no value",
+		`1 "a`:        "unterminated quote",
+		"1 --scale=2": "unknown flag --scale=2",
+	}
+	for text, want := range errs {
+		if _, err := bort.ParseArgs(spec, text); err == nil || err.Error() != want {
+			t.Errorf("%s: got %v, want %s", text, err, want)
+		}
+	}
+	if _, err := bort.ParseArgs(spec[:3], "1 2"); err == nil || err.Error() != "unexpected 2" {
+		t.Errorf("extra argument: got %v", err)
+	}
+}
+
+func TestParseRawArgs(t *testing.T) {
+	spec := []bort.Arg{{Name: "location", Type: bort.RestArg}}
+	for text, want := range map[string]string{
+		" O'Fallon, MO ":      "O'Fallon, MO",
+		"-33.9,151.2":         "-33.9,151.2",
+		`--units "New  York"`: `--units "New  York"`,
+	} {
+		if args, err := bort.ParseArgs(spec, text); err != nil || args.String("location") != want {
+			t.Errorf("%s: got %+v, %v, want %q", text, args, err, want)
+		}
+	}
+	if _, err := bort.ParseArgs(spec, "  "); err == nil || err.Error() != "missing location" {
+		t.Errorf("empty: got %v", err)
+	}
+	spec[0].Optional = true
+	if args, err := bort.ParseArgs(spec, ""); err != nil || args.Has("location") {
+		t.Errorf("optional: got %+v, %v", args, err)
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	h := borttest.New(t, "")
+	spec := []bort.Arg{
+		{Name: "times", Short: "t", Type: bort.IntArg, Flag: true},
+		{Name: "word", Type: bort.StringArg},
+	}
+	bort.RegisterCommandArgs("repeat", "repeat a word", spec, func(ctx context.Context, in, out *bort.Message, args *bort.Args) error {
+		out.Type = bort.PrivMsg
+		for i := 0; i < args.Int("times"); i++ {
+			out.Text += args.String("word")
+		}
+		return nil
+	})
+	msgs, _ := h.Command("repeat", "-t 3 'ha '")
+	if got, want := borttest.Texts(msgs), []string{"ha ha ha "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	msgs, _ = h.Command("repeat", "-t x")
+	want := []string{"repeat: times must be an integer\nusage: repeat [-t|--times=N] <word>"}
+	if got := borttest.Texts(msgs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
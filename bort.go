@@ -3,37 +3,39 @@
 // The bot consists of the bort command, which handles the IRC connection, and
 // the bortplug command, which handles plugins.  The bortplug command can be
 // stopped, recompiled with different or reconfigured plugins, and restarted
-// while the bort command stays commected to the IRC server.
+// while the bort command stays commected to the IRC server.  Bort may also
+// use several bortplug instances, reach them as JSON over HTTP (see
+// HTTPHandler), or, built with the inproc tag, have plugins linked in; the
+// README describes these setups, and bort's -console flag.
 //
 // Plugins may implement commands, respond to matched text, or push messages
 // asynchronously.  Plugins are compiled into the bortplug command.  To enable
 // a plugin, add 'import _ "plugin_import_path"' to cmd/bortplug/plugins.go.
+// The extern plugin hosts plugins written in other languages.
+//
+// Plugins register handlers with RegisterCommand, RegisterCommandArgs,
+// RegisterCommandGroup, and RegisterMatcher, push messages with Push, PushAt,
+// and PushAfter, run jobs with RegisterJob, keep data with PluginBucket, and
+// log with Logger.  RegisterMiddleware wraps message processing.  The borttest
+// package helps test plugins, and the ircfmt package formats text.
 //
 // Bort looks for a JSON configuration file in ~/.config/bort/bort.conf, which
 // can be overridden with a command line parameter.  Bort prioritizes command
 // line parameter values, followed by configuration file, and finally, default
 // values.  Plugins have access to the configuration file data, and may look
-// for values of an appropriate key.
+// for values of an appropriate key.  The file may also be YAML or TOML (see
+// LoadConfig), values may be overridden per channel (see GetChannelConfig) and
+// by environment variables (see ApplyOverrides), and it is checked against the
+// sections declared with RegisterConfig and reloaded with ReloadConfig.
+//
+// Both commands log as configured by LogConfig, and serve health checks and
+// metrics with MonitorHandler.
 package bort
 
-import (
-	"encoding/json"
-	"io/ioutil"
-	"log"
-	"os/user"
-	"path/filepath"
-)
-
-const (
-	// default address for bort/bortplug communication
-	DefaultAddress = ":8075"
-	cfgFilename    = "bort.conf"
-)
+import "context"
 
-var (
-	configData     []byte
-	defaultCfgFile string
-)
+// default address for bort/bortplug communication
+const DefaultAddress = ":8075"
 
 // MessageType is a bitmapped IRC message type.
 type MessageType int
@@ -64,34 +66,20 @@ type Message struct {
 	Command string
 	Args    string
 	Match   string
+	Raw     string // Text with IRC formatting, which is stripped from Text
 }
 
 // HandleFunc provides an interface for handling IRC messages.
 type HandleFunc func(in, out *Message) error
 
-// LoadConfig loads the given or default config file
-func LoadConfig(cfg interface{}, cfgFile string) error {
-	if cfgFile == "" {
-		cfgFile = defaultCfgFile
-	}
-	cfgData, err := ioutil.ReadFile(cfgFile)
-	if err != nil {
-		return err
-	}
-	configData = cfgData
-	return GetConfig(cfg)
-}
-
-// GetConfig populates cfg with data from the configuration file
-func GetConfig(cfg interface{}) error {
-	return json.Unmarshal(configData, cfg)
-}
+// ContextHandleFunc is a HandleFunc that is passed a context, which is canceled
+// when the handler's deadline passes or bortplug shuts down.  Handlers that do
+// I/O should use it to give up promptly.
+type ContextHandleFunc func(ctx context.Context, in, out *Message) error
 
-func init() {
-	usr, err := user.Current()
-	if err != nil {
-		log.Println("error determining home directory")
-		return
+// withContext adapts a HandleFunc to a ContextHandleFunc, ignoring the context.
+func (h HandleFunc) withContext() ContextHandleFunc {
+	return func(ctx context.Context, in, out *Message) error {
+		return h(in, out)
 	}
-	defaultCfgFile = filepath.Join(usr.HomeDir, ".config", "bort", cfgFilename)
 }
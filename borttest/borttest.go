@@ -0,0 +1,173 @@
+// Package borttest provides a harness for testing bort plugins without IRC,
+// bort, or bortplug.
+//
+// A test imports the plugin under test (which registers itself in its init
+// function) and creates a Harness, which runs plugin setup on an isolated
+// registry with the given configuration.  Simulated messages are processed as
+// bortplug would, and replies are returned for inspection:
+//
+//	func TestFlip(t *testing.T) {
+//		h := borttest.New(t, `{"Flip": {"Flipper": "flip "}}`)
+//		msgs, err := h.Command("flip", "")
+//		...
+//	}
+package borttest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ianremmler/bort"
+)
+
+const (
+	// DefaultNick is the nick that simulated messages come from.
+	DefaultNick = "tester"
+	// DefaultChannel is the channel that simulated messages are sent to.
+	DefaultChannel = "#test"
+	// OutboxSize is the size of the push queue.
+	OutboxSize = 100
+	// PollPeriod is how often Advance simulates bort pulling pushed messages.
+	PollPeriod = 5 * time.Second
+)
+
+// Harness simulates messages to plugins and captures their replies and
+// pushes.  Nick and Channel determine the origin of simulated messages.
+type Harness struct {
+	Nick    string
+	Channel string
+	Clock   *Clock
+
+	t       testing.TB
+	plug    *bort.Plugin
+	cfgFile string
+}
+
+// New isolates the plugin registry, loads config as the bort configuration,
+// and runs plugin setup.  The registry is restored when the test finishes.
+func New(t testing.TB, config string) *Harness {
+	t.Helper()
+	restore := bort.Isolate()
+	t.Cleanup(restore)
+
+	if config == "" {
+		config = "{}"
+	}
+	cfgFile := filepath.Join(t.TempDir(), "bort.conf")
+	if err := os.WriteFile(cfgFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bort.LoadConfig(&struct{}{}, cfgFile); err != nil {
+		t.Fatalf("loading config: %s", err)
+	}
+	clock := NewClock()
+	bort.SetClock(clock)
+	if err := bort.PluginInit(OutboxSize); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Harness{
+		Nick:    DefaultNick,
+		Channel: DefaultChannel,
+		Clock:   clock,
+		t:       t,
+		plug:    &bort.Plugin{},
+		cfgFile: cfgFile,
+	}
+}
+
+// Reload replaces the configuration with config and reloads it, as on SIGHUP.
+func (h *Harness) Reload(config string) error {
+	h.t.Helper()
+	if err := os.WriteFile(h.cfgFile, []byte(config), 0644); err != nil {
+		h.t.Fatal(err)
+	}
+	return bort.ReloadConfig()
+}
+
+// Send processes a message and returns the replies.  Empty Context and Nick
+// fields are filled in from the harness.
+func (h *Harness) Send(in *bort.Message) ([]bort.Message, error) {
+	h.t.Helper()
+	if in.Context == "" {
+		in.Context = h.Channel
+	}
+	if in.Nick == "" {
+		in.Nick = h.Nick
+	}
+	msgs := []bort.Message{}
+	err := h.plug.Process(in, &msgs)
+	return msgs, err
+}
+
+// Say simulates text said in the channel.
+func (h *Harness) Say(text string) ([]bort.Message, error) {
+	h.t.Helper()
+	return h.Send(&bort.Message{Type: bort.PrivMsg, Text: text})
+}
+
+// Act simulates an action performed in the channel.
+func (h *Harness) Act(text string) ([]bort.Message, error) {
+	h.t.Helper()
+	return h.Send(&bort.Message{Type: bort.Action, Text: text})
+}
+
+// Command simulates a command given in the channel.
+func (h *Harness) Command(cmd, args string) ([]bort.Message, error) {
+	h.t.Helper()
+	text := strings.TrimSpace(cmd + " " + args)
+	return h.Send(&bort.Message{Type: bort.PrivMsg, Text: text, Command: cmd, Args: args})
+}
+
+// Join simulates nick joining the channel.
+func (h *Harness) Join(nick string) ([]bort.Message, error) {
+	h.t.Helper()
+	return h.Send(&bort.Message{Type: bort.Join, Nick: nick, Text: nick})
+}
+
+// Part simulates nick leaving the channel.
+func (h *Harness) Part(nick string) ([]bort.Message, error) {
+	h.t.Helper()
+	return h.Send(&bort.Message{Type: bort.Part, Nick: nick, Text: nick})
+}
+
+// Pushes returns the messages pushed by plugins since the last call.
+func (h *Harness) Pushes() []bort.Message {
+	h.t.Helper()
+	msgs := []bort.Message{}
+	if err := h.plug.Pull(struct{}{}, &msgs); err != nil {
+		h.t.Fatal(err)
+	}
+	return msgs
+}
+
+// Advance moves the clock forward by d while simulating bort attached and
+// pulling pushed messages every PollPeriod, so scheduled jobs run as they
+// become due.  It returns the messages pushed, including any already pending.
+func (h *Harness) Advance(d time.Duration) []bort.Message {
+	h.t.Helper()
+	msgs := h.Pushes()
+	for d > 0 {
+		step := PollPeriod
+		if d < step {
+			step = d
+		}
+		h.Clock.Advance(step)
+		d -= step
+		bort.RunJobs()
+		msgs = append(msgs, h.Pushes()...)
+	}
+	return msgs
+}
+
+// Texts returns the text of each message.
+func Texts(msgs []bort.Message) []string {
+	texts := []string{}
+	for i := range msgs {
+		texts = append(texts, msgs[i].Text)
+	}
+	return texts
+}
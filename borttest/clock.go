@@ -0,0 +1,69 @@
+package borttest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a bort.Clock whose time only changes when advanced, so tests of
+// time dependent plugins are fast and deterministic.
+type Clock struct {
+	mut    sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+type timer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewClock creates a Clock set to an arbitrary fixed time.
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock has been
+// advanced by d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	t := &timer{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t.ch
+	}
+	c.timers = append(c.timers, t)
+	return t.ch
+}
+
+// Set sets the clock's time, firing any timers that expire.
+func (c *Clock) Set(now time.Time) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.now = now
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- now
+	}
+	c.timers = pending
+}
+
+// Advance moves the clock forward by d, firing any timers that expire.
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
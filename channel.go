@@ -0,0 +1,197 @@
+package bort
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// channelConfigKey is the key of the top level configuration value holding
+// per-channel overrides.
+const channelConfigKey = "ChannelConfig"
+
+var corePkg = reflect.TypeOf(Plugin{}).PkgPath()
+
+// channelConfig is configuration data with a channel's overrides applied.  It
+// is parsed when the configuration is loaded, rather than for each message.
+type channelConfig struct {
+	data []byte
+	core coreConfig
+}
+
+// GetChannelConfig populates cfg like GetConfig, but with the overrides for
+// channel applied.  Overrides are given in the ChannelConfig value, whose keys
+// are channels and values have the same layout as the top level.  Objects are
+// merged with the global configuration, and null values remove keys.  Plugins
+// should call it with a message's Context to honor per-channel settings.  For
+// contexts without overrides, such as private messages, it is equivalent to
+// GetConfig.
+func GetChannelConfig(channel string, cfg interface{}) error {
+	return json.Unmarshal(channelSettings(channel).data, cfg)
+}
+
+// channelSettings returns the configuration for channel.
+func channelSettings(channel string) *channelConfig {
+	cfgMut.RLock()
+	defer cfgMut.RUnlock()
+
+	if cc, ok := chanConfigs[strings.ToLower(channel)]; ok {
+		return cc
+	}
+	if cc, ok := chanConfigs[""]; ok {
+		return cc
+	}
+	return &channelConfig{}
+}
+
+// parseChannels parses configuration data for each channel with overrides,
+// keyed by lower case name, and for other contexts, keyed by "".
+func parseChannels(data []byte) (map[string]*channelConfig, error) {
+	cfgs := map[string]*channelConfig{}
+	for _, channel := range append([]string{""}, channelsIn(data)...) {
+		chData, err := channelData(data, channel)
+		if err != nil {
+			return nil, err
+		}
+		cc := &channelConfig{data: chData}
+		if err := json.Unmarshal(chData, &cc.core); err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", channelConfigKey, channel, err)
+		}
+		cfgs[strings.ToLower(channel)] = cc
+	}
+	return cfgs, nil
+}
+
+// ConfigChannels lists the channels that have configuration overrides.
+func ConfigChannels() []string {
+	cfgMut.RLock()
+	data := configData
+	cfgMut.RUnlock()
+
+	return channelsIn(data)
+}
+
+// PluginEnabled reports whether the named plugin is enabled in channel,
+// according to the state set with the plugin admin command, the Plugins
+// configuration value, and the channel's overrides, in order of priority.  A
+// plugin is named by its package, and is enabled unless configured otherwise.
+// Administrators set the state with "plugin enable|disable <plugin>
+// [channel|*]", and it is kept in plugins.json next to the configuration file,
+// or the file given by the PluginState value, so it survives restarts.
+func PluginEnabled(plugin, channel string) bool {
+	return pluginEnabled(channelPlugins(channel), plugin)
+}
+
+// channelPlugins returns whether plugins are enabled in channel, keyed by
+// lower case name.
+func channelPlugins(channel string) map[string]bool {
+	plugins := map[string]bool{}
+	for name, on := range channelSettings(channel).core.Plugins {
+		plugins[strings.ToLower(name)] = on
+	}
+
+	cfgMut.RLock()
+	defer cfgMut.RUnlock()
+
+	for _, ch := range []string{allChannels, strings.ToLower(channel)} {
+		for name, on := range pluginState[ch] {
+			plugins[name] = on
+		}
+	}
+	return plugins
+}
+
+// pluginEnabled reports whether plugin is enabled according to plugins, as
+// returned by channelPlugins.  The core is always enabled.
+func pluginEnabled(plugins map[string]bool, plugin string) bool {
+	if plugin == "" {
+		return true
+	}
+	if enabled, ok := plugins[strings.ToLower(plugin)]; ok {
+		return enabled
+	}
+	return true
+}
+
+// channelData returns configuration data with the overrides for channel
+// merged in, and the per-channel overrides removed.
+func channelData(data []byte, channel string) ([]byte, error) {
+	tree := map[string]interface{}{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	key := findKey(tree, channelConfigKey)
+	if _, ok := tree[key]; !ok {
+		return data, nil
+	}
+	chans, _ := tree[key].(map[string]interface{})
+	delete(tree, key)
+	if over, ok := chans[findKey(chans, channel)].(map[string]interface{}); ok {
+		mergeTree(tree, over)
+	}
+	return json.Marshal(tree)
+}
+
+// channelsIn lists the channels with overrides in configuration data.
+func channelsIn(data []byte) []string {
+	tree := map[string]interface{}{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil
+	}
+	chans, _ := tree[findKey(tree, channelConfigKey)].(map[string]interface{})
+	names := []string{}
+	for name := range chans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mergeTree merges over into tree.  Objects are merged recursively, keys
+// matching case-insensitively as in decoding, null values delete keys, and
+// other values replace those in tree.
+func mergeTree(tree, over map[string]interface{}) {
+	for overKey, overVal := range over {
+		key := findKey(tree, overKey)
+		if overVal == nil {
+			delete(tree, key)
+			continue
+		}
+		sub, isObj := tree[key].(map[string]interface{})
+		overSub, overIsObj := overVal.(map[string]interface{})
+		if isObj && overIsObj {
+			mergeTree(sub, overSub)
+			continue
+		}
+		tree[key] = overVal
+	}
+}
+
+// callerPlugin returns the name of the package that called the function
+// calling callerPlugin, which identifies the plugin registering a handler or
+// pushing a message, or "" for the core package.
+func callerPlugin() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return ""
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return ""
+	}
+	name := fn.Name() // such as "example.com/plugin/heckle.setup.func1"
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	pkg := name[:slash+1+dot]
+	if pkg == corePkg {
+		return ""
+	}
+	return path.Base(pkg)
+}
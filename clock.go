@@ -0,0 +1,50 @@
+package bort
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	clock    Clock        = realClock{}
+	clockMut sync.RWMutex // guards clock
+)
+
+// Clock provides the current time and timers.  Plugins that deal with time
+// should use Now and After rather than the time package, so tests can control
+// time with SetClock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SetClock sets the clock used by Now and After.
+func SetClock(c Clock) {
+	clockMut.Lock()
+	defer clockMut.Unlock()
+
+	clock = c
+}
+
+func getClock() Clock {
+	clockMut.RLock()
+	defer clockMut.RUnlock()
+
+	return clock
+}
+
+// Now returns the current time according to the clock.
+func Now() time.Time {
+	return getClock().Now()
+}
+
+// After waits for the duration to elapse according to the clock, then sends
+// the current time on the returned channel.
+func After(d time.Duration) <-chan time.Time {
+	return getClock().After(d)
+}
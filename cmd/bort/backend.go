@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ianremmler/bort"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	dialTimeout   = 2 * time.Second
+	retryPeriod   = 5 * time.Second
+	inprocAddress = "inproc"
+)
+
+var (
+	errUnavailable = errors.New("bortplug unavailable")
+
+	// dialInproc, if set, connects to plugins linked into bort
+	dialInproc func() (caller, error)
+	// shutdownInproc, if set, shuts down plugins linked into bort
+	shutdownInproc func()
+
+	// commands provided by package bort, which every backend should handle
+	coreCommands = map[string]bool{"help": true, "reload": true, "plugin": true, "jobs": true}
+
+	// HTTP endpoints corresponding to RPC methods
+	httpPaths = map[string]string{
+		"Plugin.Process":  "/process",
+		"Plugin.Pull":     "/pull",
+		"Plugin.Commands": "/commands",
+		"Plugin.Plugins":  "/plugins",
+	}
+)
+
+// caller makes calls to a bortplug instance.  It is satisfied by rpc.Client.
+type caller interface {
+	Call(method string, args, reply interface{}) error
+	Close() error
+}
+
+// backend is a connection to a bortplug instance.
+type backend struct {
+	addr      string
+	rpcc      caller
+	cmds      map[string]bool
+	plugins   map[string]bool // nil if the backend can't list them
+	lastTry   time.Time
+	connects  int
+	connected bool
+}
+
+// newBackends creates a backend for each address.
+func newBackends(addrs []string) []*backend {
+	bes := []*backend{}
+	for _, addr := range addrs {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			bes = append(bes, &backend{addr: addr})
+		}
+	}
+	return bes
+}
+
+// connect connects to the backend's RPC socket and fetches the names of the
+// commands it handles.  Failed attempts are retried at most once per
+// retryPeriod, so an unavailable backend doesn't stall message handling.
+func (b *backend) connect() error {
+	if b.rpcc != nil {
+		return nil
+	}
+	if time.Since(b.lastTry) < retryPeriod {
+		return errUnavailable
+	}
+	b.lastTry = time.Now()
+
+	rpcc, err := dial(b.addr)
+	if err != nil {
+		return err
+	}
+	b.rpcc = rpcc
+	if err := b.refresh(); err != nil {
+		if b.rpcc != nil {
+			b.rpcc.Close()
+			b.rpcc = nil
+		}
+		return err
+	}
+	if b.connects > 0 {
+		rpcReconnects.Inc(b.addr)
+	}
+	b.connects++
+	b.setConnected(true)
+	slog.Info("connected to bortplug", "address", b.addr)
+	return nil
+}
+
+// setConnected records whether the backend is connected, for readiness and
+// metrics.
+func (b *backend) setConnected(connected bool) {
+	if connected == b.connected {
+		return
+	}
+	b.connected = connected
+	if connected {
+		rpcLive.Add(1)
+		rpcConnected.Set(1, b.addr)
+	} else {
+		rpcLive.Add(-1)
+		rpcConnected.Set(0, b.addr)
+	}
+}
+
+// refresh fetches the names of the commands and plugins the backend handles,
+// which change as plugins register commands, such as when an extern plugin
+// restarts.  Older backends can't list their plugins, which isn't an error.
+func (b *backend) refresh() error {
+	cmds := []string{}
+	if err := b.call("Plugin.Commands", struct{}{}, &cmds); err != nil {
+		return err
+	}
+	b.cmds = map[string]bool{}
+	for _, cmd := range cmds {
+		b.cmds[cmd] = true
+	}
+	plugins := []string{}
+	b.plugins = nil
+	if b.rpcc != nil && b.rpcc.Call("Plugin.Plugins", struct{}{}, &plugins) == nil {
+		b.plugins = map[string]bool{}
+		for _, name := range plugins {
+			b.plugins[strings.ToLower(name)] = true
+		}
+	}
+	return nil
+}
+
+// call calls the named RPC method, handling errors from the backend.
+func (b *backend) call(method string, args, reply interface{}) error {
+	err := b.rpcc.Call(method, args, reply)
+	var netErr net.Error
+	switch {
+	case err == nil:
+	case err == rpc.ErrShutdown, err == io.EOF, err == io.ErrUnexpectedEOF,
+		errors.As(err, &netErr):
+		slog.Warn("disconnected from bortplug", "address", b.addr)
+		rpcErrors.Inc(b.addr)
+		b.setConnected(false)
+		b.rpcc.Close()
+		b.rpcc = nil
+		b.cmds = nil
+	default:
+		rpcErrors.Inc(b.addr)
+		slog.Error("bortplug call failed", "address", b.addr, "method", method, "err", err)
+	}
+	return err
+}
+
+// linkedInproc reports whether plugins linked into bort are among the
+// backends, in which case they share bort's core configuration.  Call with mut
+// held.
+func linkedInproc() bool {
+	for _, b := range backends {
+		if b.addr == inprocAddress {
+			return true
+		}
+	}
+	return false
+}
+
+// route connects to available backends and returns those that should process
+// the message.  A plugin command goes only to the backend that registered it,
+// and enabling or disabling a plugin only to the backends that have it, while
+// other messages are fanned out to all backends so each can run its matchers
+// or core commands.
+func route(in *bort.Message) []*backend {
+	live := []*backend{}
+	for _, b := range backends {
+		if b.connect() == nil {
+			live = append(live, b)
+		}
+	}
+	if in.Command != "" && !coreCommands[in.Command] {
+		for _, b := range live {
+			if b.cmds[in.Command] {
+				return []*backend{b}
+			}
+		}
+	}
+	if args := strings.Fields(in.Args); in.Command == "plugin" && len(args) >= 2 &&
+		(args[0] == "enable" || args[0] == "disable") {
+		return pluginBackends(live, args[1])
+	}
+	return live
+}
+
+// pluginBackends returns the backends in live that have the named plugin, or
+// can't say, or failing that the first, so that it reports the plugin unknown.
+func pluginBackends(live []*backend, plugin string) []*backend {
+	has := []*backend{}
+	for _, b := range live {
+		if b.plugins == nil || b.plugins[strings.ToLower(plugin)] {
+			has = append(has, b)
+		}
+	}
+	if len(has) == 0 && len(live) > 0 {
+		has = live[:1]
+	}
+	return has
+}
+
+// mergeReplies combines the replies of several backends to a core command, so
+// the user gets one reply rather than one per backend.  Help listings are
+// merged into one, and duplicate replies are dropped.
+func mergeReplies(in *bort.Message, msgs []bort.Message) []bort.Message {
+	merged := []bort.Message{}
+	seen := map[string]bool{}
+	helpIdx := -1
+	helpLines := map[string]string{}
+	for _, msg := range msgs {
+		if in.Command == "help" && msg.Type == bort.PrivMsg && msg.Context == in.Nick {
+			for _, line := range strings.Split(strings.TrimRight(msg.Text, "\n"), "\n") {
+				if name, help, ok := strings.Cut(line, ":"); ok {
+					helpLines[name] = strings.TrimSpace(help)
+				}
+			}
+			if helpIdx < 0 {
+				helpIdx = len(merged)
+				merged = append(merged, msg)
+			}
+			continue
+		}
+		key := fmt.Sprintf("%d\x00%s\x00%s", msg.Type, msg.Context, msg.Text)
+		if !seen[key] {
+			seen[key] = true
+			merged = append(merged, msg)
+		}
+	}
+	if helpIdx >= 0 {
+		names := []string{}
+		for name := range helpLines {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		buf := &bytes.Buffer{}
+		tabWrite := tabwriter.NewWriter(buf, 2, 0, 1, ' ', 0)
+		for _, name := range names {
+			fmt.Fprintf(tabWrite, "%s:\t%s\n", name, helpLines[name])
+		}
+		tabWrite.Flush()
+		merged[helpIdx].Text = buf.String()
+	}
+	return merged
+}
+
+// dial connects to a bortplug instance.  The address scheme selects the
+// transport: http:// for JSON over HTTP, ws:// for JSON-RPC over WebSocket, or
+// none for net/rpc over TCP.  In builds with plugins linked in, the address
+// "inproc" selects them.
+func dial(addr string) (caller, error) {
+	switch {
+	case addr == inprocAddress:
+		if dialInproc == nil {
+			return nil, errors.New("plugins not linked in (build with -tags inproc)")
+		}
+		return dialInproc()
+	case strings.HasPrefix(addr, "http://"), strings.HasPrefix(addr, "https://"):
+		base := strings.TrimRight(addr, "/")
+		return &httpCaller{base: base, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case strings.HasPrefix(addr, "ws://"), strings.HasPrefix(addr, "wss://"):
+		config, err := websocket.NewConfig(addr, "http://localhost/")
+		if err != nil {
+			return nil, err
+		}
+		config.Dialer = &net.Dialer{Timeout: dialTimeout}
+		ws, err := websocket.DialConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return jsonrpc.NewClient(ws), nil
+	}
+	con, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(con), nil
+}
+
+// httpCaller makes calls to a bortplug instance's JSON HTTP endpoints.
+type httpCaller struct {
+	base   string
+	client *http.Client
+}
+
+func (c *httpCaller) Call(method string, args, reply interface{}) error {
+	path, ok := httpPaths[method]
+	if !ok {
+		return fmt.Errorf("%s: unknown method", method)
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.base+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		res := struct{ Error string }{}
+		if json.NewDecoder(resp.Body).Decode(&res) != nil || res.Error == "" {
+			return errors.New(resp.Status)
+		}
+		return errors.New(res.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+func (c *httpCaller) Close() error {
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ianremmler/bort"
+)
+
+func TestMergeReplies(t *testing.T) {
+	in := &bort.Message{Nick: "tester", Command: "help"}
+	msgs := []bort.Message{
+		{Type: bort.PrivMsg, Context: "tester", Text: "help:   list commands\nflip:   flip text\n"},
+		{Type: bort.PrivMsg, Context: "tester", Text: "forecast: get the forecast\nhelp:     list commands\n"},
+	}
+	want := []bort.Message{{Type: bort.PrivMsg, Context: "tester",
+		Text: "flip:     flip text\nforecast: get the forecast\nhelp:     list commands\n"}}
+	if got := mergeReplies(in, msgs); !reflect.DeepEqual(got, want) {
+		t.Errorf("help: got %q", got)
+	}
+
+	in = &bort.Message{Nick: "tester", Context: "#test", Command: "reload"}
+	msgs = []bort.Message{
+		{Type: bort.PrivMsg, Context: "#test", Text: "configuration reloaded"},
+		{Type: bort.PrivMsg, Context: "#test", Text: "configuration reloaded"},
+		{Type: bort.PrivMsg, Context: "tester", Text: "configuration reloaded"},
+	}
+	if got := mergeReplies(in, msgs); !reflect.DeepEqual(got, []bort.Message{msgs[0], msgs[2]}) {
+		t.Errorf("reload: got %q", got)
+	}
+}
+
+// fakeCaller is a connected backend that makes no calls.
+type fakeCaller struct{}
+
+func (fakeCaller) Call(method string, args, reply interface{}) error { return nil }
+func (fakeCaller) Close() error                                      { return nil }
+
+func TestRoute(t *testing.T) {
+	a := &backend{addr: "a", rpcc: fakeCaller{}, cmds: map[string]bool{"flip": true},
+		plugins: map[string]bool{"flip": true}}
+	b := &backend{addr: "b", rpcc: fakeCaller{}, cmds: map[string]bool{"forecast": true},
+		plugins: map[string]bool{"forecast": true}}
+	old := &backend{addr: "old", rpcc: fakeCaller{}}
+	defer func(orig []*backend) { backends = orig }(backends)
+	backends = []*backend{a, b}
+
+	tests := []struct {
+		cmd, args string
+		want      []*backend
+	}{
+		{"flip", "text", []*backend{a}},
+		{"forecast", "", []*backend{b}},
+		{"", "", []*backend{a, b}},
+		{"help", "", []*backend{a, b}},
+		{"plugin", "", []*backend{a, b}},
+		{"plugin", "disable Forecast #test", []*backend{b}},
+		{"plugin", "enable nosuch", []*backend{a}},
+	}
+	for _, test := range tests {
+		in := &bort.Message{Command: test.cmd, Args: test.args}
+		if got := route(in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s %s: got %v", test.cmd, test.args, got)
+		}
+	}
+
+	backends = []*backend{a, old}
+	in := &bort.Message{Command: "plugin", Args: "enable forecast"}
+	if got := route(in); !reflect.DeepEqual(got, []*backend{old}) {
+		t.Errorf("unlisted plugins: got %v", got)
+	}
+}
+
+func TestReady(t *testing.T) {
+	b := &backend{addr: "a", rpcc: fakeCaller{}}
+	defer setLive(false)
+	defer b.setConnected(false)
+
+	// ready mustn't wait for mut, which a slow handler may hold
+	mut.Lock()
+	defer mut.Unlock()
+
+	if ready() == nil {
+		t.Error("ready before connecting")
+	}
+	setLive(true)
+	if ready() == nil {
+		t.Error("ready without bortplug")
+	}
+	b.setConnected(true)
+	b.setConnected(true)
+	if err := ready(); err != nil {
+		t.Error(err)
+	}
+	b.setConnected(false)
+	if ready() == nil {
+		t.Error("ready after disconnecting")
+	}
+}
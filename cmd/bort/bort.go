@@ -1,62 +1,121 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/rpc"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/ircfmt"
 	"github.com/sorcix/bot"
 	"github.com/sorcix/irc"
 	"github.com/sorcix/irc/ctcp"
 )
 
+// nickSeparators may follow the bot's nick when it is addressed.
+const nickSeparators = ":,;> "
+
 var (
 	// flags
-	flags   Config
-	cfgFile string
+	flags    Config
+	cfgFile  string
+	console  bool
+	checkCfg bool
+
+	defaults Config
 
-	mut    sync.Mutex
-	isLive bool
-	botc   *bot.Client
-	rpcc   *rpc.Client
+	mut      sync.Mutex
+	isLive   bool
+	nick     string // current nick, as set by the server
+	sender   irc.Sender
+	backends []*backend
+	trigs    = map[string]*Config{} // per-channel triggers, until reloaded
 )
 
 // configuration, initialized to defaults
 var cfg = &Config{
-	Nick:       "bort",
-	Server:     "irc.freenode.net:6667",
-	Channel:    "#bort",
-	Address:    bort.DefaultAddress,
-	CmdPrefix:  "bort:",
-	PollPeriod: 5,
+	Nick:        "bort",
+	Server:      "irc.freenode.net:6667",
+	Channel:     "#bort",
+	Address:     defaultPlugAddress,
+	CmdPrefix:   "bort:",
+	PollPeriod:  5,
+	ConsoleNick: "user",
 }
 
-// Config holds the configurable values for the program.
+// Config holds the configurable values for the program.  Channels and
+// Addresses, if set, list multiple channels and bortplug addresses and take
+// precedence over Channel and Address.  The first channel is the default
+// context for pushed messages.  Likewise, CmdPrefixes, if set, lists multiple
+// command prefixes, such as "!", and takes precedence over CmdPrefix.  If
+// NickAddress is set, messages addressing the bot by its current nick, such as
+// "bort, forecast" or "bort forecast", are also commands.  If CmdIgnoreCase is
+// set, prefixes match without regard to case, and command names are converted
+// to lower case.  If MonitorAddress is set, health checks and metrics are
+// served at that address (see bort.MonitorHandler).
 type Config struct {
-	Nick       string
-	Server     string
-	Channel    string
-	Address    string
-	CmdPrefix  string
-	PollPeriod uint
+	Nick           string
+	Server         string
+	Channel        string
+	Channels       []string
+	Address        string
+	Addresses      []string
+	CmdPrefix      string
+	CmdPrefixes    []string
+	NickAddress    bool
+	CmdIgnoreCase  bool
+	PollPeriod     uint
+	ConsoleNick    string
+	MonitorAddress string
+}
+
+// Validate checks the configuration for invalid values.
+func (c *Config) Validate() error {
+	cp := *c
+	return finishConfig(&cp)
 }
 
 func main() {
 	flag.Parse()
+	if checkCfg {
+		os.Exit(checkConfig())
+	}
 	config()
-	if len(cfg.Channel) < 2 || !strings.HasPrefix(cfg.Channel, "#") {
-		log.Fatalf("'%s' is not a valid channel", cfg.Channel)
+	backends = newBackends(cfg.Addresses)
+	// set up linked in plugins now, rather than when the first message arrives
+	for _, b := range backends {
+		if b.addr != inprocAddress {
+			continue
+		}
+		if err := b.connect(); err != nil {
+			log.Fatal(err)
+		}
 	}
-	if cfg.PollPeriod < 1 {
-		cfg.PollPeriod = 1
+	if cfg.MonitorAddress != "" {
+		go serveMonitor()
 	}
 	go pollPushes()
+	go reloadOnHangup()
+	if shutdownInproc != nil {
+		go shutdownOnSignal()
+	}
+	if console {
+		runConsole()
+		if shutdownInproc != nil {
+			shutdownInproc()
+		}
+		return
+	}
+	go measureLag()
 	for {
 		run()
 	}
@@ -66,19 +125,22 @@ func main() {
 func run() {
 	con, err := irc.Dial(cfg.Server)
 	if err != nil {
-		log.Println(err)
+		slog.Error("connecting to IRC server", "server", cfg.Server, "err", err)
 		time.Sleep(time.Second)
 		return
 	}
 
-	if botc = bot.NewClient(con, handleMessage); botc == nil {
+	botc := bot.NewClient(con, handleMessage)
+	if botc == nil {
 		return
 	}
+	sender = botc
+	nick = cfg.Nick
 	botc.Identify(cfg.Nick, cfg.Nick, cfg.Nick)
 	botc.Wait()
 
 	mut.Lock()
-	isLive = false
+	setLive(false)
 	mut.Unlock()
 }
 
@@ -86,19 +148,22 @@ func run() {
 func setup(msg *irc.Message, snd irc.Sender) {
 	switch msg.Command {
 	case irc.RPL_WELCOME:
-		log.Printf("connected to IRC server %s (%s)\n", cfg.Server, msg.Name)
-		out := &irc.Message{Command: irc.JOIN, Params: []string{cfg.Channel}}
+		slog.Info("connected to IRC server", "server", cfg.Server, "name", msg.Name)
+		if len(msg.Params) > 0 {
+			nick = msg.Params[0]
+		}
+		out := &irc.Message{Command: irc.JOIN, Params: []string{strings.Join(cfg.Channels, ",")}}
 		if err := snd.Send(out); err != nil {
-			log.Println(err)
+			slog.Error("joining channels", "err", err)
 		}
 	case irc.JOIN:
-		if msg.Name != cfg.Nick {
+		if !strings.EqualFold(msg.Name, nick) {
 			break
 		}
 		if len(msg.Params) > 0 {
-			log.Printf("joined %s as %s\n", msg.Params[0], msg.Name)
+			slog.Info("joined channel", "channel", msg.Params[0], "nick", msg.Name)
 		}
-		isLive = true
+		setLive(true)
 	}
 }
 
@@ -111,19 +176,49 @@ func handleMessage(msg *irc.Message, snd irc.Sender) {
 	mut.Lock()
 	defer mut.Unlock()
 
-	if !isLive {
-		setup(msg, snd)
+	ircMessagesIn.Inc()
+	if handlePong(msg) {
 		return
 	}
-	if connectPlug() != nil {
+	if msg.Command == irc.NICK && strings.EqualFold(msg.Name, nick) {
+		nick = msg.Trailing
+		if len(msg.Params) > 0 {
+			nick = msg.Params[0]
+		}
+	}
+	if !isLive {
+		setup(msg, snd)
 		return
 	}
 
 	in := convertMsg(msg)
+	reload := in.Command == "reload" && bort.IsAdmin(in)
+	// linked in plugins' own reload command reloads the core configuration
+	if reload && !linkedInproc() {
+		start, outcome := time.Now(), "ok"
+		err := reloadConfig()
+		if err != nil {
+			slog.Error("reloading configuration", "err", err)
+			outcome = "error"
+		}
+		bort.AuditCommand(in, "bort", outcome, err, time.Since(start))
+	}
 	msgs := []bort.Message{}
-	if err := rpcc.Call("Plugin.Process", in, &msgs); err != nil {
-		handleRPCError(err)
-		return
+	routed := route(in)
+	for _, b := range routed {
+		out := []bort.Message{}
+		if err := b.call("Plugin.Process", in, &out); err != nil {
+			continue
+		}
+		msgs = append(msgs, out...)
+	}
+	if reload && linkedInproc() {
+		if err := applyConfig(); err != nil {
+			slog.Error("reloading configuration", "err", err)
+		}
+	}
+	if len(routed) > 1 && coreCommands[in.Command] {
+		msgs = mergeReplies(in, msgs)
 	}
 	sendMessages(msgs)
 }
@@ -133,29 +228,20 @@ func deliverPushes() {
 	mut.Lock()
 	defer mut.Unlock()
 
-	if !isLive || connectPlug() != nil {
-		return
-	}
-
-	msgs := []bort.Message{}
-	if err := rpcc.Call("Plugin.Pull", struct{}{}, &msgs); err != nil {
-		handleRPCError(err)
+	if !isLive {
 		return
 	}
-	sendMessages(msgs)
-}
 
-// handleRPCError handles errors from RPC calls.
-func handleRPCError(err error) {
-	switch err {
-	case nil:
-	case rpc.ErrShutdown, io.EOF, io.ErrUnexpectedEOF:
-		log.Println("disconnected from bortplug")
-		rpcc.Close()
-		rpcc = nil
-		connectPlug()
-	default:
-		log.Println(err)
+	for _, b := range backends {
+		if b.connect() != nil {
+			continue
+		}
+		msgs := []bort.Message{}
+		if err := b.call("Plugin.Pull", struct{}{}, &msgs); err != nil {
+			continue
+		}
+		sendMessages(msgs)
+		b.refresh()
 	}
 }
 
@@ -165,21 +251,49 @@ func sendMessages(msgs []bort.Message) {
 		if msgs[i].Context == "" {
 			msgs[i].Context = cfg.Channel
 		}
-		if err := send(botc, &msgs[i]); err != nil {
-			log.Println(err)
+		if err := send(sender, &msgs[i]); err != nil {
+			slog.Error("sending message", "context", msgs[i].Context, "err", err)
 		}
 	}
 }
 
 // pollPushes periodically delivers pending push messages.
 func pollPushes() {
-	t := time.Tick(time.Duration(cfg.PollPeriod) * time.Second)
 	for {
-		<-t
+		mut.Lock()
+		period := time.Duration(cfg.PollPeriod) * time.Second
+		mut.Unlock()
+		time.Sleep(period)
 		deliverPushes()
 	}
 }
 
+// shutdownOnSignal shuts down the linked in plugins, canceling their running
+// handlers and closing storage, and exits when SIGINT or SIGTERM is received.
+func shutdownOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigs
+	slog.Info("shutting down", "signal", sig)
+	shutdownInproc()
+	os.Exit(0)
+}
+
+// reloadOnHangup reloads the configuration when SIGHUP is received.
+func reloadOnHangup() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		mut.Lock()
+		if err := reloadConfig(); err != nil {
+			slog.Error("reloading configuration", "err", err)
+		} else {
+			slog.Info("reloaded configuration")
+		}
+		mut.Unlock()
+	}
+}
+
 // send sends an IRC message to the server according to its content.
 func send(snd irc.Sender, in *bort.Message) error {
 	base := irc.Message{Command: irc.PRIVMSG, Params: []string{in.Context}}
@@ -191,12 +305,14 @@ func send(snd irc.Sender, in *bort.Message) error {
 			out := base
 			out.Trailing = str
 			snd.Send(&out)
+			ircMessagesOut.Inc()
 		}
 	case bort.Action:
 		text := strings.SplitN(in.Text, "\n", 2)[0]
 		out := base
 		out.Trailing = ctcp.Action(text)
 		snd.Send(&out)
+		ircMessagesOut.Inc()
 	default:
 		return fmt.Errorf("unknown message type: %d", in.Type)
 	}
@@ -212,10 +328,15 @@ func convertMsg(imsg *irc.Message) *bort.Message {
 		User:    imsg.User,
 		Host:    imsg.Host,
 		Params:  append([]string(nil), imsg.Params...),
-		Text:    imsg.Trailing,
+		Text:    ircfmt.Strip(imsg.Trailing),
+		Raw:     imsg.Trailing,
 	}
-	if len(bmsg.Params) > 0 && bmsg.Params[0] != cfg.Channel {
-		bmsg.Context = bmsg.Nick
+	if len(bmsg.Params) > 0 {
+		if isChannel(bmsg.Params[0]) {
+			bmsg.Context = bmsg.Params[0]
+		} else {
+			bmsg.Context = bmsg.Nick
+		}
 	}
 	switch bmsg.IRCCmd {
 	case irc.PRIVMSG:
@@ -223,14 +344,18 @@ func convertMsg(imsg *irc.Message) *bort.Message {
 		if tag, text, ok := ctcp.Decode(bmsg.Text); ok && tag == ctcp.ACTION {
 			bmsg.Type = bort.Action
 			bmsg.Text = text
+			if _, raw, ok := ctcp.Decode(bmsg.Raw); ok {
+				bmsg.Raw = raw
+			}
 			break
 		}
 
 		bmsg.Type = bort.PrivMsg
-		isCmd := (bmsg.Context != cfg.Channel)
+		isCmd := !isChannel(bmsg.Context)
 		text := strings.TrimSpace(bmsg.Text)
-		if strings.HasPrefix(text, cfg.CmdPrefix) {
-			text = strings.TrimLeft(text[len(cfg.CmdPrefix):], " ")
+		trig := triggers(bmsg.Context)
+		if rest, ok := trig.stripTrigger(text); ok {
+			text = rest
 			isCmd = true
 		}
 		if isCmd {
@@ -239,6 +364,9 @@ func convertMsg(imsg *irc.Message) *bort.Message {
 				bmsg.Command = cmdAndArgs[0]
 				bmsg.Args = strings.TrimSpace(cmdAndArgs[1])
 			}
+			if trig.CmdIgnoreCase {
+				bmsg.Command = strings.ToLower(bmsg.Command)
+			}
 		}
 	case irc.JOIN:
 		bmsg.Type = bort.Join
@@ -250,49 +378,271 @@ func convertMsg(imsg *irc.Message) *bort.Message {
 	return bmsg
 }
 
-// connectPlug connects to bortplug's RPC socket.
-func connectPlug() error {
-	if rpcc != nil {
-		return nil
+// triggers returns the configuration of command triggers (prefixes, nick
+// addressing, and case) for a channel, with the overrides of its channel
+// configuration applied.  Call with mut held.
+func triggers(channel string) *Config {
+	key := strings.ToLower(channel)
+	if trig, ok := trigs[key]; ok {
+		return trig
+	}
+	trig := channelTriggers(channel)
+	trigs[key] = trig
+	return trig
+}
+
+// channelTriggers reads the configuration of command triggers for a channel,
+// as returned by triggers.  Values the channel's configuration sets override
+// those of the file and flags, even if they equal the file's.
+func channelTriggers(channel string) *Config {
+	trig := *cfg
+	chans := struct {
+		ChannelConfig map[string]map[string]json.RawMessage
+	}{}
+	if bort.GetConfig(&chans) != nil {
+		return &trig
+	}
+	var local map[string]json.RawMessage
+	for name, vals := range chans.ChannelConfig {
+		if strings.EqualFold(name, channel) {
+			local = vals
+		}
+	}
+	set := func(key string, val interface{}) bool {
+		for name, raw := range local {
+			if strings.EqualFold(name, key) {
+				return json.Unmarshal(raw, val) == nil
+			}
+		}
+		return false
+	}
+	if set("CmdPrefix", &trig.CmdPrefix) {
+		trig.CmdPrefixes = nil
+	}
+	set("CmdPrefixes", &trig.CmdPrefixes)
+	set("NickAddress", &trig.NickAddress)
+	set("CmdIgnoreCase", &trig.CmdIgnoreCase)
+	return &trig
+}
+
+// stripTrigger removes a command prefix, or nick addressing the bot, from the
+// start of text, and reports whether one was found.  Longer prefixes are tried
+// first, so "bort:" wins over "b".  Prefixes match without regard to case only
+// if CmdIgnoreCase is set, while nicks always do.  Empty prefixes are ignored.
+func (c *Config) stripTrigger(text string) (string, bool) {
+	prefixes := c.CmdPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{c.CmdPrefix}
+	}
+	prefixes = append([]string(nil), prefixes...)
+	sort.SliceStable(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	for _, prefix := range prefixes {
+		if prefix == "" || len(text) < len(prefix) {
+			continue
+		}
+		if start := text[:len(prefix)]; start == prefix || c.CmdIgnoreCase && strings.EqualFold(start, prefix) {
+			return strings.TrimLeft(text[len(prefix):], " "), true
+		}
 	}
 
-	var err error
-	rpcc, err = rpc.Dial("tcp", cfg.Address)
-	if err == nil {
-		log.Printf("connected to bortplug (%s)\n", cfg.Address)
+	self := nick
+	if self == "" {
+		self = c.Nick
+	}
+	if !c.NickAddress || len(text) <= len(self) || !strings.EqualFold(text[:len(self)], self) {
+		return text, false
 	}
-	return err
+	rest := text[len(self):]
+	if !strings.ContainsRune(nickSeparators, rune(rest[0])) {
+		return text, false // a longer word, such as "borts"
+	}
+	rest = strings.TrimLeft(rest[1:], " ")
+	return rest, rest != ""
+}
+
+// isChannel reports whether name is one of the configured channels.
+func isChannel(name string) bool {
+	for _, ch := range cfg.Channels {
+		if strings.EqualFold(ch, name) {
+			return true
+		}
+	}
+	return false
 }
 
 // config overrides defaults with config file and flag values.
 func config() {
+	defaults = *cfg
 	if err := bort.LoadConfig(cfg, cfgFile); err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalln(err)
+		}
 		log.Println(err)
 	}
+	applyFlags(cfg)
+	if err := finishConfig(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := bort.InitLogging(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkConfig reports problems with the configuration, and returns the exit
+// status.
+func checkConfig() int {
+	problems, err := bort.CheckConfig(cfgFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if p.Fatal {
+			status = 1
+		}
+	}
+	return status
+}
+
+// reloadConfig rereads the configuration file, and applies changes that
+// don't require reconnecting to the IRC server.
+func reloadConfig() error {
+	if err := bort.ReloadConfig(); err != nil {
+		return err
+	}
+	return applyConfig()
+}
+
+// applyConfig applies changes to the reloaded configuration that don't require
+// reconnecting to the IRC server.
+func applyConfig() error {
+	newCfg := defaults
+	if err := bort.GetConfig(&newCfg); err != nil {
+		return err
+	}
+	applyFlags(&newCfg)
+	if err := finishConfig(&newCfg); err != nil {
+		return err
+	}
+
+	if newCfg.Server != cfg.Server {
+		slog.Warn("server change will take effect when reconnecting")
+	}
+	if isLive && !console {
+		updateIRC(&newCfg)
+	}
+	if strings.Join(newCfg.Addresses, ",") != strings.Join(cfg.Addresses, ",") {
+		for _, b := range backends {
+			if b.rpcc != nil {
+				b.rpcc.Close()
+				b.setConnected(false)
+			}
+		}
+		backends = newBackends(newCfg.Addresses)
+	}
+	*cfg = newCfg
+	trigs = map[string]*Config{}
+	return nil
+}
+
+// updateIRC changes the nick and joins and parts channels according to a new
+// configuration.
+func updateIRC(newCfg *Config) {
+	if newCfg.Nick != cfg.Nick {
+		sender.Send(&irc.Message{Command: irc.NICK, Params: []string{newCfg.Nick}})
+	}
+	has := func(chans []string, name string) bool {
+		for _, ch := range chans {
+			if strings.EqualFold(ch, name) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ch := range newCfg.Channels {
+		if !has(cfg.Channels, ch) {
+			sender.Send(&irc.Message{Command: irc.JOIN, Params: []string{ch}})
+		}
+	}
+	for _, ch := range cfg.Channels {
+		if !has(newCfg.Channels, ch) {
+			sender.Send(&irc.Message{Command: irc.PART, Params: []string{ch}})
+		}
+	}
+}
+
+// applyFlags overrides configuration values with those of flags that were set.
+func applyFlags(c *Config) {
 	flag.Visit(func(f *flag.Flag) {
 		switch f.Name {
 		case "n":
-			cfg.Nick = flags.Nick
+			c.Nick = flags.Nick
 		case "s":
-			cfg.Server = flags.Server
+			c.Server = flags.Server
 		case "c":
-			cfg.Channel = flags.Channel
+			c.Channel = flags.Channel
+			c.Channels = strings.Split(flags.Channel, ",")
 		case "a":
-			cfg.Address = flags.Address
+			c.Address = flags.Address
+			c.Addresses = strings.Split(flags.Address, ",")
 		case "p":
-			cfg.CmdPrefix = flags.CmdPrefix
+			c.CmdPrefix = flags.CmdPrefix
+			c.CmdPrefixes = strings.Split(flags.CmdPrefix, ",")
 		case "t":
-			cfg.PollPeriod = flags.PollPeriod
+			c.PollPeriod = flags.PollPeriod
+		case "u":
+			c.ConsoleNick = flags.ConsoleNick
+		case "m":
+			c.MonitorAddress = flags.MonitorAddress
 		}
 	})
 }
 
+// finishConfig validates the configuration and fills in derived values.
+func finishConfig(c *Config) error {
+	if len(c.Channels) == 0 {
+		c.Channels = []string{c.Channel}
+	}
+	for _, ch := range c.Channels {
+		if len(ch) < 2 || !strings.HasPrefix(ch, "#") {
+			return fmt.Errorf("'%s' is not a valid channel", ch)
+		}
+	}
+	c.Channel = c.Channels[0]
+	prefixes := c.CmdPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{c.CmdPrefix}
+	}
+	for _, prefix := range prefixes {
+		if strings.TrimSpace(prefix) == "" {
+			return fmt.Errorf("empty command prefix")
+		}
+	}
+	if c.PollPeriod < 1 {
+		c.PollPeriod = 1
+	}
+	if len(c.Addresses) == 0 {
+		c.Addresses = []string{c.Address}
+	}
+	if len(newBackends(c.Addresses)) == 0 {
+		return fmt.Errorf("no bortplug address given")
+	}
+	return nil
+}
+
 func init() {
 	flag.StringVar(&flags.Nick, "n", cfg.Nick, "nick of the bot")
 	flag.StringVar(&flags.Server, "s", cfg.Server, "IRC server")
-	flag.StringVar(&flags.Channel, "c", cfg.Channel, "channel")
-	flag.StringVar(&flags.Address, "a", cfg.Address, "bortplug address")
-	flag.StringVar(&flags.CmdPrefix, "p", cfg.CmdPrefix, "command prefix")
+	flag.StringVar(&flags.Channel, "c", cfg.Channel, "channel(s), comma separated")
+	flag.StringVar(&flags.Address, "a", cfg.Address, "bortplug address(es), comma separated")
+	flag.StringVar(&flags.CmdPrefix, "p", cfg.CmdPrefix, "command prefix(es), comma separated")
 	flag.UintVar(&flags.PollPeriod, "t", cfg.PollPeriod, "plugin push message poll period in seconds")
+	flag.StringVar(&flags.ConsoleNick, "u", cfg.ConsoleNick, "nick of the console user")
+	flag.StringVar(&flags.MonitorAddress, "m", "", "health check and metrics address")
 	flag.StringVar(&cfgFile, "f", "", "configuration file")
+	flag.BoolVar(&console, "console", false, "use stdin/stdout instead of connecting to IRC")
+	flag.BoolVar(&checkCfg, "check-config", false, "check the configuration file and exit")
+	bort.RegisterConfig("", cfg)
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ianremmler/bort"
+)
+
+func TestTriggers(t *testing.T) {
+	defer bort.Isolate()()
+	defer func(orig Config, origNick string) { *cfg, nick = orig, origNick }(*cfg, nick)
+
+	cfgFile := filepath.Join(t.TempDir(), "bort.conf")
+	data := `{
+		"CmdPrefixes": ["!", "bort:"],
+		"NickAddress": true,
+		"ChannelConfig": {
+			"#plain": {"CmdPrefix": "bort:", "NickAddress": false},
+			"#Loose": {"CmdIgnoreCase": true}
+		}
+	}`
+	if err := os.WriteFile(cfgFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bort.LoadConfig(cfg, cfgFile); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CmdPrefix, cfg.CmdPrefixes = "?", []string{"?"} // as if set with -p
+	nick = "bort"
+
+	tests := []struct {
+		channel, text, want string
+		ok                  bool
+	}{
+		{"#test", "?forecast here", "forecast here", true},
+		{"#test", "!forecast", "!forecast", false},
+		{"#test", "bort: forecast", "forecast", true},
+		{"#test", "Bort, forecast", "forecast", true},
+		{"#test", "bort forecast", "forecast", true},
+		{"#test", "borts are great", "borts are great", false},
+		{"#test", "bort:", "", false},
+		{"#plain", "bort: forecast", "forecast", true},
+		{"#plain", "BORT: forecast", "BORT: forecast", false},
+		{"#plain", "?forecast", "?forecast", false},
+		{"#plain", "bort, forecast", "bort, forecast", false},
+		{"#loose", "?forecast", "forecast", true},
+	}
+	for _, test := range tests {
+		got, ok := triggers(test.channel).stripTrigger(test.text)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s %q: got %q, %t, want %q, %t", test.channel, test.text, got, ok, test.want, test.ok)
+		}
+	}
+
+	loose := triggers("#loose")
+	loose.CmdPrefixes = []string{"Bort:"}
+	if got, ok := loose.stripTrigger("BORT: forecast"); got != "forecast" || !ok {
+		t.Errorf("ignoring case: got %q, %t", got, ok)
+	}
+}
+
+func TestEmptyPrefix(t *testing.T) {
+	for _, prefixes := range [][]string{{"!", ""}, {" "}} {
+		c := Config{Channel: "#test", Address: "localhost:1", CmdPrefixes: prefixes}
+		if err := finishConfig(&c); err == nil {
+			t.Errorf("%q: expected error", prefixes)
+		}
+	}
+	c := Config{Channel: "#test", Address: "localhost:1", CmdPrefix: ""}
+	if err := finishConfig(&c); err == nil {
+		t.Error("empty CmdPrefix: expected error")
+	}
+}
+
+func TestConsoleMsg(t *testing.T) {
+	user := "user"
+	if msg := consoleMsg("/nick alice", &user); msg != nil || user != "alice" {
+		t.Errorf("/nick: got %v, nick %q", msg, user)
+	}
+	msg := consoleMsg("/msg hi", &user)
+	if msg.Prefix.Name != "alice" || msg.Params[0] != cfg.Nick || msg.Trailing != "hi" {
+		t.Errorf("/msg: got %+v", msg)
+	}
+}
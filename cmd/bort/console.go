@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/sorcix/irc"
+	"github.com/sorcix/irc/ctcp"
+)
+
+// consoleSender prints outgoing IRC messages.
+type consoleSender struct {
+	w io.Writer
+}
+
+// Send prints a message as "context <nick> text", or "context * nick text"
+// for actions.
+func (c consoleSender) Send(msg *irc.Message) error {
+	ctx := ""
+	if len(msg.Params) > 0 {
+		ctx = msg.Params[0]
+	}
+	if tag, text, ok := ctcp.Decode(msg.Trailing); ok && tag == ctcp.ACTION {
+		_, err := fmt.Fprintf(c.w, "%s * %s %s\n", ctx, cfg.Nick, text)
+		return err
+	}
+	_, err := fmt.Fprintf(c.w, "%s <%s> %s\n", ctx, cfg.Nick, msg.Trailing)
+	return err
+}
+
+// runConsole reads lines from stdin as messages from the console user, and
+// prints replies and pushed messages to stdout, so plugins can be tried out
+// without an IRC server.  Lines are said in the channel, except for these
+// commands:
+//
+//	/me text    perform an action in the channel
+//	/msg text   send a private message to the bot
+//	/join       join the channel
+//	/part       leave the channel
+//	/nick nick  change the console user's nick
+//
+// Once stdin is exhausted, pending pushes are delivered and runConsole
+// returns.
+func runConsole() {
+	snd := consoleSender{w: os.Stdout}
+	mut.Lock()
+	sender = snd
+	setLive(true)
+	user := cfg.ConsoleNick // kept across reloads once changed with /nick
+	slog.Info("console mode", "channel", cfg.Channel, "nick", user)
+	mut.Unlock()
+
+	scan := bufio.NewScanner(os.Stdin)
+	for scan.Scan() {
+		if msg := consoleMsg(scan.Text(), &user); msg != nil {
+			handleMessage(msg, snd)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		slog.Error("reading console input", "err", err)
+	}
+	deliverPushes()
+}
+
+// consoleMsg converts a line of console input from user to an irc.Message.
+// /nick changes user.
+func consoleMsg(line string, user *string) *irc.Message {
+	cmd, arg := line, ""
+	if strings.HasPrefix(line, "/") {
+		cmdAndArg := strings.SplitN(line+" ", " ", 2)
+		cmd, arg = cmdAndArg[0], strings.TrimSpace(cmdAndArg[1])
+	}
+	mut.Lock()
+	channel, botNick := cfg.Channel, cfg.Nick
+	mut.Unlock()
+
+	prefix := &irc.Prefix{Name: *user, User: *user, Host: "console"}
+	msg := &irc.Message{Prefix: prefix, Command: irc.PRIVMSG, Params: []string{channel}}
+	switch cmd {
+	case "/me":
+		msg.Trailing = ctcp.Action(arg)
+	case "/msg":
+		msg.Params = []string{botNick}
+		msg.Trailing = arg
+	case "/join":
+		msg.Command = irc.JOIN
+	case "/part":
+		msg.Command = irc.PART
+	case "/nick":
+		if arg != "" {
+			*user = arg
+		}
+		return nil
+	default:
+		msg.Trailing = line
+	}
+	return msg
+}
@@ -0,0 +1,70 @@
+//go:build inproc
+// +build inproc
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/ianremmler/bort"
+)
+
+const (
+	// defaultOutboxSize matches bortplug's default.
+	defaultOutboxSize = 10
+	// defaultPlugAddress is the default bortplug address.
+	defaultPlugAddress = inprocAddress
+)
+
+var (
+	inproc     = &inprocCaller{plug: &bort.Plugin{}}
+	inprocInit sync.Once
+	inprocErr  error // from setting up the linked in plugins
+)
+
+// inprocCaller calls plugins linked into bort directly, through the same
+// interface as an RPC client.
+type inprocCaller struct {
+	plug *bort.Plugin
+}
+
+func (c *inprocCaller) Call(method string, args, reply interface{}) error {
+	switch method {
+	case "Plugin.Process":
+		in := *args.(*bort.Message) // copy, as an RPC call would
+		return c.plug.Process(&in, reply.(*[]bort.Message))
+	case "Plugin.Pull":
+		return c.plug.Pull(struct{}{}, reply.(*[]bort.Message))
+	case "Plugin.Commands":
+		return c.plug.Commands(struct{}{}, reply.(*[]string))
+	case "Plugin.Plugins":
+		return c.plug.Plugins(struct{}{}, reply.(*[]string))
+	}
+	return fmt.Errorf("%s: unknown method", method)
+}
+
+func (c *inprocCaller) Close() error {
+	return nil
+}
+
+// initInproc sets up the linked in plugins, once the configuration is loaded.
+func initInproc() (caller, error) {
+	inprocInit.Do(func() {
+		plugCfg := struct{ OutboxSize uint }{defaultOutboxSize}
+		if err := bort.GetConfig(&plugCfg); err != nil {
+			slog.Error("reading plugin configuration", "err", err)
+		}
+		inprocErr = bort.PluginInit(plugCfg.OutboxSize)
+	})
+	if inprocErr != nil {
+		return nil, inprocErr
+	}
+	return inproc, nil
+}
+
+func init() {
+	dialInproc = initInproc
+	shutdownInproc = bort.PluginShutdown
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ianremmler/bort"
+	"github.com/sorcix/irc"
+)
+
+// lagPeriod is how often the server is pinged to measure lag.
+const lagPeriod = 30 * time.Second
+
+var (
+	ircMessagesIn  = bort.NewCounter("bort_irc_messages_in_total", "Messages received from the IRC server.")
+	ircMessagesOut = bort.NewCounter("bort_irc_messages_out_total", "Messages sent to the IRC server.")
+	ircConnected   = bort.NewGauge("bort_irc_connected", "Whether bort is connected to the IRC server and has joined.")
+	ircLag         = bort.NewGauge("bort_irc_lag_seconds", "Round trip time of the last ping to the IRC server.")
+	rpcConnected   = bort.NewGauge("bort_rpc_connected", "Whether bort is connected to bortplug, by address.", "address")
+	rpcReconnects  = bort.NewCounter("bort_rpc_reconnects_total", "Reconnections to bortplug, by address.", "address")
+	rpcErrors      = bort.NewCounter("bort_rpc_errors_total", "Failed calls to bortplug, by address.", "address")
+
+	// the outstanding lag ping, guarded by mut
+	lagToken string
+	lagSent  time.Time
+
+	// readiness, kept apart from mut, which may be held until a handler's
+	// deadline
+	ircLive atomic.Bool
+	rpcLive atomic.Int64 // connected bortplug instances
+)
+
+// serveMonitor serves health checks and metrics.
+func serveMonitor() {
+	slog.Info("serving monitoring", "address", cfg.MonitorAddress)
+	if err := http.ListenAndServe(cfg.MonitorAddress, bort.NewMonitorHandler(ready)); err != nil {
+		slog.Error("serving monitoring", "address", cfg.MonitorAddress, "err", err)
+	}
+}
+
+// ready reports whether bort is connected to the IRC server and to at least
+// one bortplug instance.
+func ready() error {
+	if !ircLive.Load() {
+		return errors.New("not connected to IRC server")
+	}
+	if rpcLive.Load() == 0 {
+		return errors.New("not connected to bortplug")
+	}
+	return nil
+}
+
+// setLive records whether bort is connected to the IRC server and has joined.
+// Call with mut held.
+func setLive(live bool) {
+	isLive = live
+	ircLive.Store(live)
+	if live {
+		ircConnected.Set(1)
+	} else {
+		ircConnected.Set(0)
+	}
+}
+
+// measureLag periodically pings the IRC server, so the lag can be measured
+// when it replies.
+func measureLag() {
+	for {
+		time.Sleep(lagPeriod)
+		mut.Lock()
+		if isLive && sender != nil {
+			lagSent = time.Now()
+			lagToken = "lag" + strconv.FormatInt(lagSent.UnixNano(), 36)
+			sender.Send(&irc.Message{Command: irc.PING, Params: []string{lagToken}})
+		}
+		mut.Unlock()
+	}
+}
+
+// handlePong records the lag if msg is the reply to the outstanding ping, and
+// reports whether it was.  Call with mut held.
+func handlePong(msg *irc.Message) bool {
+	if msg.Command != irc.PONG || lagToken == "" {
+		return false
+	}
+	token := msg.Trailing
+	if token == "" && len(msg.Params) > 0 {
+		token = msg.Params[len(msg.Params)-1]
+	}
+	if token != lagToken {
+		return false
+	}
+	ircLag.Set(time.Since(lagSent).Seconds())
+	lagToken = ""
+	return true
+}
@@ -0,0 +1,9 @@
+//go:build !inproc
+// +build !inproc
+
+package main
+
+import "github.com/ianremmler/bort"
+
+// defaultPlugAddress is the default bortplug address.
+const defaultPlugAddress = bort.DefaultAddress
@@ -0,0 +1,15 @@
+//go:build inproc
+// +build inproc
+
+package main
+
+// Plugins linked into bort when built with the inproc tag.  This list should
+// generally match cmd/bortplug/plugins.go.
+import (
+	_ "github.com/ianremmler/bort/plugin/calc"
+	_ "github.com/ianremmler/bort/plugin/extern"
+	_ "github.com/ianremmler/bort/plugin/flip"
+	_ "github.com/ianremmler/bort/plugin/forecast"
+	_ "github.com/ianremmler/bort/plugin/heckle"
+	_ "github.com/ianremmler/bort/plugin/urltitle"
+)
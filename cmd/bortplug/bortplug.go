@@ -1,10 +1,17 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/ianremmler/bort"
@@ -12,10 +19,14 @@ import (
 
 var (
 	// flags
-	flags   Config
-	cfgFile string
+	flags    Config
+	cfgFile  string
+	checkCfg bool
 
 	plug = &bort.Plugin{}
+
+	connections = bort.NewCounter("bort_plug_connections_total", "RPC connections accepted from bort.")
+	connected   = bort.NewGauge("bort_plug_connected", "Whether bort is connected by RPC.")
 )
 
 // configuration, initialized to defaults
@@ -24,10 +35,24 @@ var cfg = &Config{
 	OutboxSize: 10,
 }
 
-// Config holds the configurable values for the program.
+// Config holds the configurable values for the program.  If HTTPAddress is
+// set, plugins are also served as JSON over HTTP and WebSocket at that address.
+// If PlugMonitorAddress is set, health checks and metrics are served at that
+// address (see bort.MonitorHandler).  It is named so as not to clash with
+// bort's MonitorAddress in a shared configuration file.
 type Config struct {
-	Address    string
-	OutboxSize uint
+	Address            string
+	HTTPAddress        string
+	OutboxSize         uint
+	PlugMonitorAddress string
+}
+
+// Validate checks the configuration for invalid values.
+func (c *Config) Validate() error {
+	if c.OutboxSize == 0 {
+		return &bort.FieldError{Path: []string{"OutboxSize"}, Err: errors.New("must be positive")}
+	}
+	return nil
 }
 
 func main() {
@@ -36,8 +61,22 @@ func main() {
 	}
 
 	flag.Parse()
+	if checkCfg {
+		os.Exit(checkConfig())
+	}
 	config()
-	bort.PluginInit(cfg.OutboxSize)
+	if err := bort.PluginInit(cfg.OutboxSize); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.HTTPAddress != "" {
+		go serveHTTP()
+	}
+	if cfg.PlugMonitorAddress != "" {
+		go serveMonitor()
+	}
+	go reloadOnHangup()
+	go shutdownOnSignal()
 
 	listen, err := net.Listen("tcp", cfg.Address)
 	if err != nil {
@@ -46,33 +85,116 @@ func main() {
 	for {
 		con, err := listen.Accept()
 		if err != nil {
-			log.Println(err)
+			slog.Error("accepting connection", "err", err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		log.Printf("connected to bort (%s)\n", cfg.Address)
+		slog.Info("connected to bort", "address", cfg.Address)
+		connections.Inc()
+		connected.Set(1)
 		rpc.ServeConn(con)
-		log.Println("disconnected from bort")
+		connected.Set(0)
+		slog.Warn("disconnected from bort")
+	}
+}
+
+// serveHTTP serves plugins as JSON over HTTP and WebSocket.
+func serveHTTP() {
+	slog.Info("serving HTTP", "address", cfg.HTTPAddress)
+	if err := http.ListenAndServe(cfg.HTTPAddress, bort.NewHTTPHandler(plug)); err != nil {
+		slog.Error("serving HTTP", "address", cfg.HTTPAddress, "err", err)
 	}
 }
 
+// serveMonitor serves health checks and metrics.  Bortplug is ready once bort
+// is attached, pulling pushed messages.
+func serveMonitor() {
+	slog.Info("serving monitoring", "address", cfg.PlugMonitorAddress)
+	ready := func() error {
+		if !bort.Attached() {
+			return errors.New("bort not attached")
+		}
+		return nil
+	}
+	if err := http.ListenAndServe(cfg.PlugMonitorAddress, bort.NewMonitorHandler(ready)); err != nil {
+		slog.Error("serving monitoring", "address", cfg.PlugMonitorAddress, "err", err)
+	}
+}
+
+// reloadOnHangup reloads the plugin configuration when SIGHUP is received.
+// Changes to bortplug's own configuration require a restart.
+func reloadOnHangup() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		if err := bort.ReloadConfig(); err != nil {
+			slog.Error("reloading configuration", "err", err)
+		} else {
+			slog.Info("reloaded configuration")
+		}
+	}
+}
+
+// shutdownOnSignal cancels running handlers, closes storage, and exits when
+// SIGINT or SIGTERM is received.
+func shutdownOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigs
+	slog.Info("shutting down", "signal", sig)
+	bort.PluginShutdown()
+	os.Exit(0)
+}
+
+// checkConfig reports problems with the configuration, and returns the exit
+// status.
+func checkConfig() int {
+	problems, err := bort.CheckConfig(cfgFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if p.Fatal {
+			status = 1
+		}
+	}
+	return status
+}
+
 // config overrides defaults with config file and flag values.
 func config() {
 	if err := bort.LoadConfig(cfg, cfgFile); err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalln(err)
+		}
 		log.Println(err)
 	}
 	flag.Visit(func(f *flag.Flag) {
 		switch f.Name {
 		case "a":
 			cfg.Address = flags.Address
+		case "w":
+			cfg.HTTPAddress = flags.HTTPAddress
 		case "o":
 			cfg.OutboxSize = flags.OutboxSize
+		case "m":
+			cfg.PlugMonitorAddress = flags.PlugMonitorAddress
 		}
 	})
+	if err := bort.InitLogging(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func init() {
 	flag.StringVar(&flags.Address, "a", cfg.Address, "bortplug address")
+	flag.StringVar(&flags.HTTPAddress, "w", cfg.HTTPAddress, "HTTP/WebSocket JSON address")
 	flag.UintVar(&flags.OutboxSize, "o", cfg.OutboxSize, "outbox size")
+	flag.StringVar(&flags.PlugMonitorAddress, "m", "", "health check and metrics address")
 	flag.StringVar(&cfgFile, "f", "", "configuration file")
+	flag.BoolVar(&checkCfg, "check-config", false, "check the configuration file and exit")
+	bort.RegisterConfig("", cfg)
 }
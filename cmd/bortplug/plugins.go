@@ -2,6 +2,7 @@ package main
 
 import (
 	_ "github.com/ianremmler/bort/plugin/calc"
+	_ "github.com/ianremmler/bort/plugin/extern"
 	_ "github.com/ianremmler/bort/plugin/flip"
 	_ "github.com/ianremmler/bort/plugin/forecast"
 	_ "github.com/ianremmler/bort/plugin/heckle"
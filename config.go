@@ -0,0 +1,236 @@
+package bort
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	configData     []byte
+	chanConfigs    map[string]*channelConfig
+	configFile     string
+	defaultCfgFile string
+	admins         []*regexp.Regexp
+	reloadFuncs    = []ReloadFunc{}
+	cfgMut         sync.RWMutex // guards configData, chanConfigs, configFile, admins, reloadFuncs, sections, and pluginState
+	reloadMut      sync.Mutex   // serializes reloads, so reload functions don't run concurrently
+)
+
+// coreConfig holds the configuration values used by the core.
+type coreConfig struct {
+	Admins          []string
+	Plugins         map[string]bool
+	PluginState     string
+	Storage         string
+	HandlerTimeout  uint
+	HandlerTimeouts map[string]uint
+	Middleware      []string
+	NoColor         bool
+	ChannelConfig   map[string]map[string]interface{}
+}
+
+// Config is raw configuration file data, as passed to reload functions.
+type Config []byte
+
+// Decode populates cfg with the configuration data.
+func (c Config) Decode(cfg interface{}) error {
+	return json.Unmarshal(c, cfg)
+}
+
+// ReloadFunc is called with the old and new configuration data when the
+// configuration is reloaded, so a plugin can apply changes.
+type ReloadFunc func(old, new Config) error
+
+// LoadConfig loads the given or default config file, and applies overrides
+// from environment variables and secrets files (see ApplyOverrides).  The file
+// may be written in YAML or TOML, which allow comments, if its name ends in
+// .yaml, .yml, or .toml; it is converted to the equivalent JSON structure, so
+// plugins needn't care.  If no file is given, bort.conf, bort.yaml, bort.yml,
+// and bort.toml are tried in that order.  If the
+// file doesn't exist, the overrides are applied to an empty configuration, and
+// the error is returned after populating cfg.  If the configuration has fatal
+// problems (see CheckConfig), it is not loaded, and ConfigProblems listing
+// them is returned.
+func LoadConfig(cfg interface{}, cfgFile string) error {
+	if cfgFile == "" {
+		cfgFile = defaultCfgFile
+	}
+	cfgData, readErr := ioutil.ReadFile(cfgFile)
+	if readErr != nil {
+		if !os.IsNotExist(readErr) {
+			return readErr
+		}
+		cfgData = emptyConfig(cfgFile)
+	}
+	cfgData, err := prepareConfig(cfgFile, cfgData)
+	if err != nil {
+		return err
+	}
+	if err := setConfig(cfgFile, cfgData); err != nil {
+		return err
+	}
+	if err := GetConfig(cfg); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// GetConfig populates cfg with data from the configuration file
+func GetConfig(cfg interface{}) error {
+	cfgMut.RLock()
+	defer cfgMut.RUnlock()
+
+	return json.Unmarshal(configData, cfg)
+}
+
+// RegisterReload registers a function to be called when the configuration is
+// reloaded.  Plugins should typically call this from their setup function.
+func RegisterReload(fn ReloadFunc) {
+	cfgMut.Lock()
+	defer cfgMut.Unlock()
+
+	reloadFuncs = append(reloadFuncs, fn)
+}
+
+// ReloadConfig rereads the configuration file last loaded, and if it has no
+// fatal problems (see CheckConfig), replaces the configuration and calls the
+// registered reload functions.  Both commands reload on SIGHUP, as do
+// administrators (see IsAdmin) with the reload command.  Reloads are
+// serialized, so reload functions needn't guard against each other, but they
+// may run concurrently with handlers, so should replace rather than modify
+// shared values.
+func ReloadConfig() error {
+	reloadMut.Lock()
+	defer reloadMut.Unlock()
+
+	cfgMut.RLock()
+	cfgFile, oldData := configFile, configData
+	cfgMut.RUnlock()
+
+	if cfgFile == "" {
+		return errors.New("no configuration file loaded")
+	}
+	newData, err := ioutil.ReadFile(cfgFile)
+	if err != nil {
+		return err
+	}
+	if newData, err = prepareConfig(cfgFile, newData); err != nil {
+		return err
+	}
+	if err := setConfig(cfgFile, newData); err != nil {
+		return err
+	}
+
+	cfgMut.RLock()
+	fns := append([]ReloadFunc(nil), reloadFuncs...)
+	cfgMut.RUnlock()
+	errs := ""
+	if err := reloadLogging(); err != nil {
+		errs += fmt.Sprintln(err)
+	}
+	for _, fn := range fns {
+		if err := fn(oldData, newData); err != nil {
+			errs += fmt.Sprintln(err)
+		}
+	}
+	if errs != "" {
+		return errors.New(errs)
+	}
+	return nil
+}
+
+// prepareConfig checks configuration data read from cfgFile for fatal
+// problems, converts it to JSON, and applies overrides.
+func prepareConfig(cfgFile string, data []byte) ([]byte, error) {
+	env := os.Environ()
+	fatal := ConfigProblems{}
+	for _, p := range checkConfig(cfgFile, data, env) {
+		if p.Fatal {
+			fatal = append(fatal, p)
+		}
+	}
+	if len(fatal) > 0 {
+		return nil, fatal
+	}
+	data, err := toJSON(cfgFile, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", cfgFile, err)
+	}
+	data, err = ApplyOverrides(data, filepath.Dir(cfgFile), env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", cfgFile, err)
+	}
+	return data, nil
+}
+
+// setConfig sets the configuration data, which should have been checked by
+// prepareConfig.
+func setConfig(cfgFile string, cfgData []byte) error {
+	core := coreConfig{}
+	if err := json.Unmarshal(cfgData, &core); err != nil {
+		return fmt.Errorf("%s: %s", cfgFile, err)
+	}
+	adminREs := []*regexp.Regexp{}
+	for _, mask := range core.Admins {
+		adminREs = append(adminREs, maskRE(mask))
+	}
+	chanCfgs, err := parseChannels(cfgData)
+	if err != nil {
+		return fmt.Errorf("%s: %s", cfgFile, err)
+	}
+
+	cfgMut.Lock()
+	defer cfgMut.Unlock()
+
+	configFile, configData, chanConfigs, admins = cfgFile, cfgData, chanCfgs, adminREs
+	return nil
+}
+
+// maskRE converts an IRC mask, where '*' matches any string and '?' any
+// character, to a regular expression.  Masks without a '!' match only the nick,
+// others match "nick!user@host".
+func maskRE(mask string) *regexp.Regexp {
+	if !strings.Contains(mask, "!") {
+		mask += "!*@*"
+	}
+	pat := regexp.QuoteMeta(mask)
+	pat = strings.Replace(pat, `\*`, ".*", -1)
+	pat = strings.Replace(pat, `\?`, ".", -1)
+	return regexp.MustCompile("(?i)^" + pat + "$")
+}
+
+// IsAdmin reports whether the sender of a message is a bot administrator, by
+// matching "nick!user@host" against the masks listed in the Admins
+// configuration value, such as "alice" or "*!*@example.com".
+func IsAdmin(in *Message) bool {
+	cfgMut.RLock()
+	defer cfgMut.RUnlock()
+
+	id := in.Nick + "!" + in.User + "@" + in.Host
+	for _, re := range admins {
+		if re.MatchString(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	RegisterConfig("", &coreConfig{})
+
+	usr, err := user.Current()
+	if err != nil {
+		slog.Warn("error determining home directory", "err", err)
+		return
+	}
+	defaultCfgFile = findConfig(filepath.Join(usr.HomeDir, ".config", "bort"))
+}
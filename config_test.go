@@ -0,0 +1,165 @@
+package bort_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestIsAdmin(t *testing.T) {
+	borttest.New(t, `{"Admins": ["alice", "*!*@trusted.example.com", "b?b!bob@*"]}`)
+	tests := []struct {
+		nick, user, host string
+		want             bool
+	}{
+		{"alice", "x", "example.com", true},
+		{"Alice", "x", "example.com", true},
+		{"alice2", "x", "example.com", false},
+		{"eve", "eve", "trusted.example.com", true},
+		{"bob", "bob", "users/bob", true},
+		{"bob", "notbob", "users/bob", false},
+	}
+	for _, test := range tests {
+		in := &bort.Message{Nick: test.nick, User: test.user, Host: test.host}
+		if got := bort.IsAdmin(in); got != test.want {
+			t.Errorf("%s!%s@%s: got %t, want %t", test.nick, test.user, test.host, got, test.want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	h := borttest.New(t, `{"Admins": ["admin"], "Value": 1}`)
+	calls := 0
+	bort.RegisterReload(func(old, new bort.Config) error {
+		calls++
+		o, n := struct{ Value int }{}, struct{ Value int }{}
+		old.Decode(&o)
+		new.Decode(&n)
+		if n.Value != 2 || (o.Value != 1 && o.Value != 2) {
+			t.Errorf("got old %d, new %d", o.Value, n.Value)
+		}
+		return nil
+	})
+
+	h.Nick = "user"
+	msgs, _ := h.Command("reload", "")
+	if calls != 0 || len(msgs) != 1 || msgs[0].Text != "reload: permission denied" {
+		t.Errorf("non-admin reload: got %q, %d calls", borttest.Texts(msgs), calls)
+	}
+	if err := h.Reload(`{"Admins": ["admin"], "Value": `); err == nil {
+		t.Error("expected error reloading invalid config")
+	}
+	if err := h.Reload(`{"Admins": ["admin"], "Value": 2, "Log": {"Level": "loud"}}`); err == nil {
+		t.Error("expected error reloading config with an invalid section")
+	}
+	if calls != 0 {
+		t.Error("reload function called for invalid config")
+	}
+
+	if err := h.Reload(`{"Admins": ["admin"], "Value": 2}`); err != nil || calls != 1 {
+		t.Errorf("reload: got %v, %d calls", err, calls)
+	}
+	h.Nick = "admin"
+	msgs, err := h.Command("reload", "")
+	if err != nil || calls != 2 || len(msgs) != 1 || msgs[0].Text != "configuration reloaded" {
+		t.Errorf("admin reload: got %q, %v, %d calls", borttest.Texts(msgs), err, calls)
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	defer bort.Isolate()()
+	dir := t.TempDir()
+	files := map[string]string{
+		"bort.conf": `{"Nick": "bort", "Retorts": {"(?i)hi": "hello"}, "Flip": {"Flipper": "x"}}`,
+		"bort.yaml": `
+# comments are allowed
+Nick: bort
+Retorts:
+  "(?i)hi": hello # greeting
+Flip:
+  Flipper: x
+`,
+		"bort.toml": `
+# comments are allowed
+Nick = "bort"
+
+[Retorts]
+"(?i)hi" = "hello" # greeting
+
+[Flip]
+Flipper = "x"
+`,
+	}
+	type config struct {
+		Nick    string
+		Retorts map[string]string
+		Flip    struct{ Flipper string }
+	}
+	want := config{Nick: "bort", Retorts: map[string]string{"(?i)hi": "hello"}}
+	want.Flip.Flipper = "x"
+	for name, data := range files {
+		cfgFile := filepath.Join(dir, name)
+		if err := os.WriteFile(cfgFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got := config{}
+		if err := bort.LoadConfig(&got, cfgFile); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+
+	cfgFile := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(cfgFile, []byte("Nick: [bort"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := bort.LoadConfig(&config{}, cfgFile); err == nil {
+		t.Error("expected error loading invalid YAML")
+	}
+}
+
+func TestChannelConfig(t *testing.T) {
+	borttest.New(t, `{
+		"Flip": {"Flipper": "a", "Chiller": "b"},
+		"Plugins": {"heckle": false},
+		"ChannelConfig": {
+			"#Chan": {"Flip": {"Chiller": "c"}, "Plugins": {"heckle": true, "flip": false}}
+		}
+	}`)
+	type config struct {
+		Flip          struct{ Flipper, Chiller string }
+		ChannelConfig interface{}
+	}
+	got := config{}
+	if err := bort.GetChannelConfig("#chan", &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Flip.Flipper != "a" || got.Flip.Chiller != "c" || got.ChannelConfig != nil {
+		t.Errorf("got %+v", got)
+	}
+	if chans := bort.ConfigChannels(); len(chans) != 1 || chans[0] != "#Chan" {
+		t.Errorf("got channels %q", chans)
+	}
+	tests := []struct {
+		plugin, channel string
+		want            bool
+	}{
+		{"heckle", "#other", false},
+		{"heckle", "#chan", true},
+		{"flip", "#other", true},
+		{"flip", "#chan", false},
+		{"calc", "#chan", true},
+	}
+	for _, test := range tests {
+		if got := bort.PluginEnabled(test.plugin, test.channel); got != test.want {
+			t.Errorf("%s in %s: got %t, want %t", test.plugin, test.channel, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package bort
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const (
+	delayedBucket = "bort/delayed" // not a valid plugin name, so can't collide
+
+	// failed deliveries, such as to a full outbox, are retried
+	deliverRetries     = 5
+	deliverRetryPeriod = time.Minute
+)
+
+var (
+	delayed   = map[uint64]*delayedPush{}
+	delayedID uint64 // guarded, with delayed, by schedMut
+)
+
+// delayedPush is a message to be pushed later.
+type delayedPush struct {
+	Plugin  string
+	At      time.Time
+	Message Message
+	Retries int
+	id      uint64
+}
+
+// PushAt enqueues an outgoing message pushed by a plugin, to be delivered at t.
+// As with jobs, delivery waits until bort is attached.  Pending messages are
+// kept in storage, so they survive restarts.  If the push fails, such as when
+// the outbox is full, it is retried a few times, a minute apart.  It returns an
+// ID which may be used to cancel delivery with CancelPush.
+func PushAt(t time.Time, msg *Message) (uint64, error) {
+	return pushAt(callerPlugin(), t, msg)
+}
+
+// PushAfter enqueues an outgoing message pushed by a plugin, to be delivered
+// after d (see PushAt).
+func PushAfter(d time.Duration, msg *Message) (uint64, error) {
+	return pushAt(callerPlugin(), Now().Add(d), msg)
+}
+
+// pushAt stores a message from the named plugin for delivery at t.
+func pushAt(plugin string, t time.Time, msg *Message) (uint64, error) {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	dp := &delayedPush{Plugin: plugin, At: t, Message: *msg, id: delayedID + 1}
+	data, err := json.Marshal(dp)
+	if err != nil {
+		return 0, err
+	}
+	if err := getStore().Put(delayedBucket, seqKey(dp.id), data); err != nil {
+		return 0, err
+	}
+	delayedID = dp.id
+	delayed[dp.id] = dp
+	wakeScheduler()
+	return dp.id, nil
+}
+
+// CancelPush cancels delivery of a message pushed with PushAt or PushAfter,
+// and returns whether it was pending.
+func CancelPush(id uint64) bool {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	if _, ok := delayed[id]; !ok {
+		return false
+	}
+	delete(delayed, id)
+	if err := getStore().Delete(delayedBucket, seqKey(id)); err != nil {
+		slog.Error("removing delayed push", "id", id, "err", err)
+	}
+	return true
+}
+
+// duePushes returns the delayed pushes to deliver now, in order, and the time
+// until the next is due, or 0 if none are.
+func duePushes(now time.Time) ([]*delayedPush, time.Duration) {
+	due := []*delayedPush{}
+	var wait time.Duration
+	for id, dp := range delayed {
+		if dp.At.After(now) {
+			if d := dp.At.Sub(now); wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if attached(now) {
+			due = append(due, dp)
+			delete(delayed, id)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].At.Equal(due[j].At) {
+			return due[i].At.Before(due[j].At)
+		}
+		return due[i].id < due[j].id
+	})
+	return due, wait
+}
+
+// deliver pushes the message and removes it from storage.  If the push fails,
+// it is retried after deliverRetryPeriod, up to deliverRetries times.
+func (dp *delayedPush) deliver() {
+	err := push(dp.Plugin, &dp.Message)
+	if err != nil {
+		delayedFailures.Inc(dp.Plugin)
+		if dp.Retries < deliverRetries {
+			pluginLogger(dp.Plugin).Warn("delivering delayed push, will retry", "id", dp.id, "err", err)
+			dp.retry()
+			return
+		}
+		pluginLogger(dp.Plugin).Error("delivering delayed push", "id", dp.id, "err", err)
+	}
+	if err := getStore().Delete(delayedBucket, seqKey(dp.id)); err != nil {
+		slog.Error("removing delayed push", "id", dp.id, "err", err)
+	}
+}
+
+// retry reschedules a failed delivery.
+func (dp *delayedPush) retry() {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	dp.Retries++
+	dp.At = Now().Add(deliverRetryPeriod)
+	if data, err := json.Marshal(dp); err == nil {
+		if err := getStore().Put(delayedBucket, seqKey(dp.id), data); err != nil {
+			slog.Error("storing delayed push", "id", dp.id, "err", err)
+		}
+	}
+	delayed[dp.id] = dp
+	wakeScheduler()
+}
+
+// loadDelayed loads the pending delayed pushes from storage.
+func loadDelayed() error {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	delayed = map[uint64]*delayedPush{}
+	return getStore().ForEach(delayedBucket, func(key string, value []byte) error {
+		dp := &delayedPush{}
+		if err := json.Unmarshal(value, dp); err != nil {
+			return err
+		}
+		fmt.Sscan(key, &dp.id)
+		if dp.id > delayedID {
+			delayedID = dp.id
+		}
+		delayed[dp.id] = dp
+		return nil
+	})
+}
@@ -0,0 +1,70 @@
+package bort_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestPushAfter(t *testing.T) {
+	h := borttest.New(t, "")
+	pushAfter := func(d time.Duration, text string) uint64 {
+		id, err := bort.PushAfter(d, &bort.Message{Type: bort.PrivMsg, Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	pushAfter(20*time.Second, "b")
+	pushAfter(10*time.Second, "a")
+	id := pushAfter(15*time.Second, "canceled")
+	if !bort.CancelPush(id) || bort.CancelPush(id) {
+		t.Error("canceling: expected only first cancel to succeed")
+	}
+	if got := h.Advance(5 * time.Second); len(got) != 0 {
+		t.Errorf("early: got %q", borttest.Texts(got))
+	}
+	if got, want := borttest.Texts(h.Advance(15*time.Second)), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// pending pushes survive restarts, and wait for bort to attach
+	pushAfter(time.Minute, "c")
+	bort.PluginInit(borttest.OutboxSize) // restart
+	h.Clock.Advance(10 * time.Minute)
+	bort.RunJobs()
+	got := h.Pushes() // attaches
+	bort.RunJobs()
+	got = append(got, h.Pushes()...)
+	if got, want := borttest.Texts(got), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after restart: got %q, want %q", got, want)
+	}
+	if got := h.Advance(time.Minute); len(got) != 0 {
+		t.Errorf("after delivery: got %q", borttest.Texts(got))
+	}
+}
+
+func TestPushAfterRetry(t *testing.T) {
+	h := borttest.New(t, "")
+	bort.PluginInit(1)
+	h.Pushes() // attaches
+	if _, err := bort.PushAfter(10*time.Second, &bort.Message{Type: bort.PrivMsg, Text: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	h.Clock.Advance(10 * time.Second)
+	bort.Push(&bort.Message{Type: bort.PrivMsg, Text: "first"})
+	bort.RunJobs() // outbox full
+	if got, want := borttest.Texts(h.Pushes()), []string{"first"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("full: got %q, want %q", got, want)
+	}
+	if got, want := borttest.Texts(h.Advance(time.Minute)), []string{"late"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("retried: got %q, want %q", got, want)
+	}
+	if want := `bort_delayed_push_failures_total{plugin="bort_test"} 1`; !strings.Contains(metricsText(t), want) {
+		t.Errorf("missing %s", want)
+	}
+}
@@ -0,0 +1,140 @@
+package bort
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configFormat is a configuration file format.
+type configFormat int
+
+const (
+	jsonFormat configFormat = iota
+	yamlFormat
+	tomlFormat
+)
+
+// cfgFilenames are the default configuration file names, in order of
+// preference.
+var cfgFilenames = []string{"bort.conf", "bort.yaml", "bort.yml", "bort.toml"}
+
+// formatOf determines the format of a configuration file by its extension.
+// Files ending in .yaml or .yml are YAML, .toml are TOML, and others JSON.
+func formatOf(cfgFile string) configFormat {
+	switch strings.ToLower(filepath.Ext(cfgFile)) {
+	case ".yaml", ".yml":
+		return yamlFormat
+	case ".toml":
+		return tomlFormat
+	}
+	return jsonFormat
+}
+
+// toJSON converts configuration data in the format of cfgFile to JSON, so it
+// has the same structure regardless of format.
+func toJSON(cfgFile string, data []byte) ([]byte, error) {
+	var val interface{}
+	switch formatOf(cfgFile) {
+	case yamlFormat:
+		if err := yaml.Unmarshal(data, &val); err != nil {
+			return nil, err
+		}
+	case tomlFormat:
+		tree := map[string]interface{}{}
+		if err := toml.Unmarshal(data, &tree); err != nil {
+			return nil, err
+		}
+		val = tree
+	default:
+		return data, nil
+	}
+	if val == nil {
+		val = map[string]interface{}{} // empty file
+	}
+	return json.Marshal(stringKeys(val))
+}
+
+// pathSep joins the keys of a path into a map key.
+const pathSep = "\x00"
+
+// yamlLines returns the lines of the keys and array elements in YAML data, by
+// their paths joined with pathSep, or nil if it can't be parsed.
+func yamlLines(data []byte) map[string]int {
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	lines := map[string]int{}
+	var walk func(node *yaml.Node, path []string)
+	walk = func(node *yaml.Node, path []string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(child, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := append(append([]string(nil), path...), node.Content[i].Value)
+				lines[strings.Join(key, pathSep)] = node.Content[i].Line
+				walk(node.Content[i+1], key)
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				elem := append(append([]string(nil), path...), strconv.Itoa(i))
+				lines[strings.Join(elem, pathSep)] = child.Line
+				walk(child, elem)
+			}
+		}
+	}
+	walk(&doc, nil)
+	return lines
+}
+
+// emptyConfig returns an empty configuration in the format of cfgFile.
+func emptyConfig(cfgFile string) []byte {
+	if formatOf(cfgFile) == tomlFormat {
+		return []byte{}
+	}
+	return []byte("{}")
+}
+
+// stringKeys converts maps with non-string keys, which YAML allows, to maps
+// with string keys, so they can be marshaled as JSON.
+func stringKeys(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = stringKeys(elem)
+		}
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, elem := range v {
+			m[fmt.Sprint(key)] = stringKeys(elem)
+		}
+		return m
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = stringKeys(elem)
+		}
+	}
+	return val
+}
+
+// findConfig returns the path of the first configuration file found in dir,
+// or of the JSON one if there are none.
+func findConfig(dir string) string {
+	for _, name := range cfgFilenames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, cfgFilenames[0])
+}
@@ -0,0 +1,170 @@
+package bort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHandlerTimeout is the handler deadline if not configured.
+	defaultHandlerTimeout = 10 * time.Second
+	// a handler is disabled if it panics panicLimit times within panicWindow
+	panicLimit  = 3
+	panicWindow = 10 * time.Minute
+	// panicReply is the reply to a message whose handler panicked.
+	panicReply = "Sorry, something went wrong."
+)
+
+var errPanic = errors.New("handler panicked")
+
+// handlerCtx is the parent of handlers' contexts, canceled by PluginShutdown.
+// It is guarded, with stopHandlers, by regMut.
+var handlerCtx, stopHandlers = context.WithCancel(context.Background())
+
+// handlerTimeout returns the deadline in channel for a handler of plugin, for
+// the named command, or "" for matchers.  Per-command values take priority over
+// per-plugin values.
+func handlerTimeout(channel, cmd, plugin string) time.Duration {
+	core := channelSettings(channel).core
+	secs := core.HandlerTimeout
+	for _, key := range []string{plugin, cmd} {
+		if s, ok := core.HandlerTimeouts[key]; ok && key != "" {
+			secs = s
+		}
+	}
+	if secs == 0 {
+		return defaultHandlerTimeout
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// handler is a registered command, match handler, or job.
+type handler struct {
+	handle ContextHandleFunc
+	plugin string
+	cmd    string // "" for matchers and jobs
+	job    string // for jobs
+	panicState
+}
+
+// panicState records the recent panics of a handler or middleware, which is
+// disabled if it panics too often.
+type panicState struct {
+	mut      sync.Mutex
+	panics   []time.Time // within panicWindow
+	disabled bool
+}
+
+// name identifies the handler in errors and logs.
+func (h *handler) name() string {
+	if h.cmd != "" {
+		return h.cmd
+	}
+	if h.job != "" {
+		return h.job + " job"
+	}
+	return h.plugin + " matcher"
+}
+
+// run calls the handler and returns its reply and outcome: "ok", "error",
+// "timeout", "canceled", or "panic".  If it doesn't return by its deadline, its
+// context is canceled and it is abandoned, and a timeout error is returned.  If
+// it panics, the panic is logged, and for commands, a generic error reply
+// returned.  If it has panicked panicLimit times within panicWindow, even after
+// its deadline, it is disabled.  Jobs are run with an empty message.
+func (h *handler) run(in *Message) (Message, string, error) {
+	start := time.Now()
+	timeout := handlerTimeout(in.Context, h.cmd, h.plugin)
+	regMut.RLock()
+	parent := handlerCtx
+	regMut.RUnlock()
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	// the handler gets copies, as it may outlive the call
+	inCopy := *in
+	out := &Message{Context: in.Context}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				pluginLogger(h.plugin).Error("handler panicked", "handler", h.name(),
+					"panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+				if h.panicked(Now()) {
+					pluginLogger(h.plugin).Warn("handler disabled", "handler", h.name(), "panics", panicLimit)
+				}
+				done <- errPanic
+			}
+		}()
+		done <- h.handle(ctx, &inCopy, out)
+	}()
+
+	select {
+	case err := <-done:
+		if err == errPanic {
+			h.record("panic", start)
+			if h.cmd == "" {
+				return Message{}, "panic", nil
+			}
+			return Message{Type: PrivMsg, Context: in.Context, Text: panicReply}, "panic", nil
+		}
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		h.record(outcome, start)
+		return *out, outcome, err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			h.record("timeout", start)
+			return Message{}, "timeout", fmt.Errorf("%s: timed out after %s", h.name(), timeout)
+		}
+		h.record("canceled", start)
+		return Message{}, "canceled", fmt.Errorf("%s: %s", h.name(), ctx.Err())
+	}
+}
+
+// record records the handler's invocation in the metrics.  Process records
+// commands in the audit log.
+func (h *handler) record(outcome string, start time.Time) {
+	handlerSeconds.Observe(time.Since(start).Seconds(), h.plugin, h.name())
+	if outcome != "ok" {
+		handlerErrors.Inc(h.plugin, h.name(), outcome)
+	}
+	if h.cmd != "" {
+		commandsTotal.Inc(h.cmd, outcome)
+	}
+}
+
+// panicked records a panic at now, and returns whether it caused the handler
+// or middleware to be disabled.
+func (p *panicState) panicked(now time.Time) bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	recent := []time.Time{now}
+	for _, t := range p.panics {
+		if now.Sub(t) < panicWindow {
+			recent = append(recent, t)
+		}
+	}
+	p.panics = recent
+	if len(p.panics) < panicLimit || p.disabled {
+		return false
+	}
+	p.disabled = true
+	return true
+}
+
+// isDisabled reports whether the handler or middleware was disabled for
+// panicking.
+func (p *panicState) isDisabled() bool {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	return p.disabled
+}
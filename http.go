@@ -0,0 +1,145 @@
+package bort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"golang.org/x/net/websocket"
+)
+
+// MessageSchema is a JSON Schema describing the JSON encoding of a Message.
+const MessageSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "bort.Message",
+  "type": "object",
+  "properties": {
+    "Type": {
+      "description": "bitmapped message type: 0 none, 2 privmsg, 4 action, 8 join, 16 part",
+      "type": "integer"
+    },
+    "Context": {"description": "channel or nick the message is to or from", "type": "string"},
+    "Text": {"description": "message text", "type": "string"},
+    "Nick": {"description": "sender's nick (incoming only)", "type": "string"},
+    "User": {"description": "sender's user name (incoming only)", "type": "string"},
+    "Host": {"description": "sender's host (incoming only)", "type": "string"},
+    "IRCCmd": {"description": "IRC command, e.g. PRIVMSG (incoming only)", "type": "string"},
+    "Params": {"description": "IRC parameters (incoming only)", "type": ["array", "null"], "items": {"type": "string"}},
+    "Command": {"description": "bort command name, if any (incoming only)", "type": "string"},
+    "Args": {"description": "bort command arguments (incoming only)", "type": "string"},
+    "Match": {"description": "text matched by a matcher (incoming only)", "type": "string"},
+    "Raw": {"description": "message text with IRC formatting codes (incoming only)", "type": "string"}
+  }
+}
+`
+
+// HTTPHandler serves the Plugin RPC calls as JSON, as an alternative to the
+// gob encoded net/rpc protocol, so backends and tools written in any language
+// can speak it.  Messages are JSON objects with the fields of Message, as
+// described by MessageSchema.  Endpoints are:
+//
+//	POST /process   Message in body, replies with an array of Messages
+//	POST /pull      replies with an array of pushed Messages
+//	GET  /commands  replies with an array of registered command names
+//	GET  /plugins   replies with an array of plugin names
+//	GET  /schema    replies with MessageSchema
+//	GET  /ws        WebSocket carrying JSON-RPC 1.0, one request or response
+//	                per frame, with methods Plugin.Process, Plugin.Pull,
+//	                Plugin.Commands, and Plugin.Plugins taking the same
+//	                parameters as above
+//
+// Errors are reported with a non-200 status and a body of the form
+// {"Error": "text"}.
+type HTTPHandler struct {
+	plug *Plugin
+	rpcs *rpc.Server
+	mux  *http.ServeMux
+}
+
+// NewHTTPHandler creates an HTTPHandler for p.
+func NewHTTPHandler(p *Plugin) *HTTPHandler {
+	h := &HTTPHandler{plug: p, rpcs: rpc.NewServer(), mux: http.NewServeMux()}
+	h.rpcs.Register(p)
+	h.mux.HandleFunc("/process", h.process)
+	h.mux.HandleFunc("/pull", h.pull)
+	h.mux.HandleFunc("/commands", h.commands)
+	h.mux.HandleFunc("/plugins", h.plugins)
+	h.mux.HandleFunc("/schema", h.schema)
+	// accept clients regardless of origin, as they are typically not browsers
+	h.mux.Handle("/ws", websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(ws *websocket.Conn) { h.rpcs.ServeCodec(jsonrpc.NewServerCodec(ws)) },
+	})
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *HTTPHandler) process(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	in := &Message{}
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	msgs := []Message{}
+	if err := h.plug.Process(in, &msgs); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, msgs)
+}
+
+func (h *HTTPHandler) pull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	msgs := []Message{}
+	if err := h.plug.Pull(struct{}{}, &msgs); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, msgs)
+}
+
+func (h *HTTPHandler) commands(w http.ResponseWriter, r *http.Request) {
+	cmds := []string{}
+	if err := h.plug.Commands(struct{}{}, &cmds); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, cmds)
+}
+
+func (h *HTTPHandler) plugins(w http.ResponseWriter, r *http.Request) {
+	plugins := []string{}
+	if err := h.plug.Plugins(struct{}{}, &plugins); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, plugins)
+}
+
+func (h *HTTPHandler) schema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write([]byte(MessageSchema))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct{ Error string }{text})
+}
@@ -0,0 +1,148 @@
+// Package ircfmt formats text with IRC formatting codes, such as bold and
+// colors, and strips them.
+//
+//	text := ircfmt.Bold("Forecast") + " " + ircfmt.Colored(ircfmt.Red, "hot")
+//
+// Clients differ in which codes they support, and channels with mode +c may
+// forbid colors, so formatting should be decoration rather than meaning.
+package ircfmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// formatting codes
+const (
+	BoldCode          = "\x02"
+	ItalicCode        = "\x1d"
+	UnderlineCode     = "\x1f"
+	StrikethroughCode = "\x1e"
+	MonospaceCode     = "\x11"
+	ReverseCode       = "\x16"
+	ColorCode         = "\x03"
+	HexColorCode      = "\x04"
+	ResetCode         = "\x0f"
+)
+
+// Color is a standard IRC color.
+type Color int
+
+// standard colors
+const (
+	White Color = iota
+	Black
+	Blue
+	Green
+	Red
+	Brown
+	Magenta
+	Orange
+	Yellow
+	LightGreen
+	Cyan
+	LightCyan
+	LightBlue
+	Pink
+	Grey
+	LightGrey
+)
+
+// Bold returns text in bold.
+func Bold(text string) string {
+	return BoldCode + text + BoldCode
+}
+
+// Italic returns text in italics.
+func Italic(text string) string {
+	return ItalicCode + text + ItalicCode
+}
+
+// Underline returns text underlined.
+func Underline(text string) string {
+	return UnderlineCode + text + UnderlineCode
+}
+
+// Strikethrough returns text struck through.
+func Strikethrough(text string) string {
+	return StrikethroughCode + text + StrikethroughCode
+}
+
+// Monospace returns text in a monospace font.
+func Monospace(text string) string {
+	return MonospaceCode + text + MonospaceCode
+}
+
+// Colored returns text in the foreground color fg.
+func Colored(fg Color, text string) string {
+	return fmt.Sprintf("%s%02d%s%s", ColorCode, fg, text, ColorCode)
+}
+
+// ColoredBG returns text in the foreground color fg on the background color bg.
+func ColoredBG(fg, bg Color, text string) string {
+	return fmt.Sprintf("%s%02d,%02d%s%s", ColorCode, fg, bg, text, ColorCode)
+}
+
+// Strip removes all formatting codes from text.
+func Strip(text string) string {
+	return strip(text, true)
+}
+
+// StripColors removes color codes from text, leaving other formatting.
+func StripColors(text string) string {
+	return strip(text, false)
+}
+
+func strip(text string, all bool) string {
+	if strings.IndexFunc(text, isCode) < 0 {
+		return text
+	}
+	b := &strings.Builder{}
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == ColorCode[0]:
+			i += colorLen(text[i+1:], 2, isDigit)
+		case c == HexColorCode[0]:
+			i += colorLen(text[i+1:], 6, isHex)
+		case all && isCode(rune(c)):
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// colorLen returns the length of the color specification, of foreground and
+// optional background colors of up to n digits, at the start of text.
+func colorLen(text string, n int, digit func(byte) bool) int {
+	count := func(s string) int {
+		i := 0
+		for i < n && i < len(s) && digit(s[i]) {
+			i++
+		}
+		return i
+	}
+	fg := count(text)
+	if fg == 0 {
+		return 0
+	}
+	if fg < len(text)-1 && text[fg] == ',' {
+		if bg := count(text[fg+1:]); bg > 0 {
+			return fg + 1 + bg
+		}
+	}
+	return fg
+}
+
+func isCode(r rune) bool {
+	return strings.ContainsRune(BoldCode+ItalicCode+UnderlineCode+StrikethroughCode+
+		MonospaceCode+ReverseCode+ColorCode+HexColorCode+ResetCode, r)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
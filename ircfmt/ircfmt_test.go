@@ -0,0 +1,38 @@
+package ircfmt
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := map[string]string{
+		Bold("b"):                    "\x02b\x02",
+		Italic("i"):                  "\x1di\x1d",
+		Colored(Red, "1"):            "\x03041\x03",
+		ColoredBG(White, Black, "x"): "\x0300,01x\x03",
+	}
+	for got, want := range tests {
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		text, all, colors string
+	}{
+		{"plain, 1,2", "plain, 1,2", "plain, 1,2"},
+		{Bold("b") + Colored(Red, "12"), "b12", "\x02b\x0212"},
+		{"\x034,12x\x03 \x039,y \x03,5z", "x ,y ,5z", "x ,y ,5z"},
+		{"\x04ff8800,000000hex\x04 \x1funder\x0f", "hex under", "hex \x1funder\x0f"},
+		{"\x03", "", ""},
+		{"\x0312,", ",", ","},
+	}
+	for _, test := range tests {
+		if got := Strip(test.text); got != test.all {
+			t.Errorf("Strip(%q) = %q, want %q", test.text, got, test.all)
+		}
+		if got := StripColors(test.text); got != test.colors {
+			t.Errorf("StripColors(%q) = %q, want %q", test.text, got, test.colors)
+		}
+	}
+}
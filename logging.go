@@ -0,0 +1,220 @@
+package bort
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logStarted bool
+	logFiles   []io.Closer // files opened for logging
+	logOut     = &logWriter{w: os.Stderr}
+	auditOut   = &logWriter{w: io.Discard}
+	auditLog   *slog.Logger // nil if auditing is off
+	logMut     sync.Mutex   // guards logStarted, logFiles, logOut, auditOut, and auditLog
+)
+
+// logWriter writes to the current log file.  Loggers write through it, so
+// those still held when the file is replaced on reload write to the new file,
+// rather than the closed one.
+type logWriter struct {
+	mut sync.Mutex
+	w   io.Writer
+}
+
+func (lw *logWriter) Write(p []byte) (int, error) {
+	lw.mut.Lock()
+	defer lw.mut.Unlock()
+
+	return lw.w.Write(p)
+}
+
+// set replaces the writer.  Once it returns, the previous one isn't written to.
+func (lw *logWriter) set(w io.Writer) {
+	lw.mut.Lock()
+	defer lw.mut.Unlock()
+
+	lw.w = w
+}
+
+// LogConfig holds the logging configuration, under the Log key.  Level is
+// debug, info (the default), warn, or error, and Format is text (the default)
+// or json.  Logs are written to File, or standard error if empty.  If Audit is
+// set, each command invoked is recorded in that file, in the same format, with
+// its nick, context, arguments, plugin, outcome, and duration, including
+// commands handled by middleware, disabled, or dropped by middleware.
+// Relative paths are relative to the configuration file.
+type LogConfig struct {
+	Level  string
+	Format string
+	File   string
+	Audit  string
+}
+
+// Validate checks the level and format.
+func (c *LogConfig) Validate() error {
+	if _, err := logLevel(c.Level); err != nil {
+		return &FieldError{Path: []string{"Level"}, Err: err}
+	}
+	switch c.Format {
+	case "", "text", "json":
+		return nil
+	}
+	return &FieldError{Path: []string{"Format"}, Err: fmt.Errorf("unknown format '%s'", c.Format)}
+}
+
+func init() {
+	RegisterConfig("Log", &LogConfig{})
+}
+
+// InitLogging sets up logging according to the Log configuration value,
+// replacing the default slog logger, which the log package also writes to.
+// The commands call it once the configuration is loaded, and it is called
+// again when the configuration is reloaded.
+func InitLogging() error {
+	cfg := struct{ Log LogConfig }{}
+	if err := GetConfig(&cfg); err != nil {
+		return err
+	}
+	level, err := logLevel(cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	files := []io.Closer{}
+	open := func(path string) (io.Writer, error) {
+		if path == "" {
+			return os.Stderr, nil
+		}
+		if !filepath.IsAbs(path) {
+			cfgMut.RLock()
+			path = filepath.Join(filepath.Dir(configFile), path)
+			cfgMut.RUnlock()
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return f, nil
+	}
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	w, err := open(cfg.Log.File)
+	if err != nil {
+		return err
+	}
+	auditW := io.Discard
+	if cfg.Log.Audit != "" {
+		if auditW, err = open(cfg.Log.Audit); err != nil {
+			closeAll()
+			return err
+		}
+	}
+
+	logMut.Lock()
+	defer logMut.Unlock()
+
+	logOut.set(w)
+	auditOut.set(auditW)
+	slog.SetDefault(slog.New(logHandler(logOut, cfg.Log.Format, level)))
+	auditLog = nil
+	if cfg.Log.Audit != "" {
+		auditLog = slog.New(logHandler(auditOut, cfg.Log.Format, slog.LevelInfo))
+	}
+	for _, f := range logFiles {
+		f.Close()
+	}
+	logStarted, logFiles = true, files
+	return nil
+}
+
+// reloadLogging sets up logging again after the configuration is reloaded,
+// if InitLogging was called.
+func reloadLogging() error {
+	logMut.Lock()
+	started := logStarted
+	logMut.Unlock()
+
+	if !started {
+		return nil
+	}
+	return InitLogging()
+}
+
+func logHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+func logLevel(name string) (slog.Level, error) {
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("unknown level '%s'", name)
+	}
+	return level, nil
+}
+
+// Logger returns the default logger, with the calling package's plugin
+// attached to its records.  Plugins should get it when logging, rather than
+// keep it, so they use the current configuration.
+func Logger() *slog.Logger {
+	return pluginLogger(callerPlugin())
+}
+
+// pluginLogger returns the default logger with plugin attached, unless it is
+// the core.
+func pluginLogger(plugin string) *slog.Logger {
+	if plugin == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("plugin", plugin)
+}
+
+// AuditCommand records a command handled outside of plugins, such as bort's
+// own handling of reload, in the audit log, if enabled.  by names what handled
+// it, and the outcome is "ok" or "error".
+func AuditCommand(in *Message, by, outcome string, err error, d time.Duration) {
+	audit(in, by, outcome, err, d)
+}
+
+// audit records a command invocation in the audit log, if enabled.  The
+// outcome is "ok", "error", "timeout", "canceled", "panic", "disabled", or
+// "dropped" (by middleware).
+func audit(in *Message, plugin, outcome string, err error, d time.Duration) {
+	logMut.Lock()
+	logger := auditLog
+	logMut.Unlock()
+
+	if logger == nil {
+		return
+	}
+	attrs := []any{
+		"nick", in.Nick,
+		"context", in.Context,
+		"command", in.Command,
+		"args", in.Args,
+		"plugin", plugin,
+		"outcome", outcome,
+		"duration", d,
+	}
+	if err != nil {
+		attrs = append(attrs, "error", strings.TrimSpace(err.Error()))
+	}
+	logger.Info("command", attrs...)
+}
@@ -0,0 +1,143 @@
+package bort_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+// readRecords reads the JSON log records in a file.
+func readRecords(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	recs := []map[string]interface{}{}
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		rec := map[string]interface{}{}
+		if err := json.Unmarshal(scan.Bytes(), &rec); err != nil {
+			t.Fatalf("%s: %s", scan.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestLogging(t *testing.T) {
+	dir := t.TempDir()
+	logFile, auditFile := filepath.Join(dir, "bort.log"), filepath.Join(dir, "audit.log")
+	cfg, _ := json.Marshal(map[string]interface{}{
+		"Log":           map[string]string{"Level": "warn", "Format": "json", "File": logFile, "Audit": auditFile},
+		"ChannelConfig": map[string]interface{}{"#off": map[string]interface{}{"Plugins": map[string]bool{"bort_test": false}}},
+	})
+	h := borttest.New(t, string(cfg))
+	if err := bort.InitLogging(); err != nil {
+		t.Fatal(err)
+	}
+	bort.RegisterCommand("echo", "echo", func(in, out *bort.Message) error {
+		out.Type = bort.PrivMsg
+		out.Text = in.Args
+		return nil
+	})
+	bort.RegisterCommand("fail", "fail", func(in, out *bort.Message) error {
+		return errors.New("failed")
+	})
+	bort.RegisterMiddleware("dropper", func(next bort.ProcessFunc) bort.ProcessFunc {
+		return func(in *bort.Message, msgs *[]bort.Message) error {
+			if in.Command == "drop" {
+				return nil
+			}
+			return next(in, msgs)
+		}
+	})
+	bort.RegisterCommand("drop", "drop", func(in, out *bort.Message) error { return nil })
+	h.Command("echo", "a b")
+	h.Command("fail", "")
+	h.Command("help", "")
+	h.Command("drop", "")
+	h.Command("unknown", "")
+	h.Channel = "#off"
+	h.Command("echo", "")
+	h.Channel = borttest.DefaultChannel
+	bort.Logger().Info("filtered")
+	bort.Logger().Warn("logged", "n", 1)
+
+	recs := readRecords(t, logFile)
+	if len(recs) != 1 || recs[0]["msg"] != "logged" || recs[0]["plugin"] != "bort_test" || recs[0]["n"] != 1.0 {
+		t.Errorf("log: got %v", recs)
+	}
+	recs = readRecords(t, auditFile)
+	want := []map[string]interface{}{
+		{"nick": borttest.DefaultNick, "context": borttest.DefaultChannel, "command": "echo", "args": "a b", "outcome": "ok"},
+		{"command": "fail", "args": "", "outcome": "error", "error": "failed"},
+		{"command": "help", "plugin": "middleware", "outcome": "ok"},
+		{"command": "drop", "plugin": "middleware", "outcome": "dropped"},
+		{"command": "echo", "context": "#off", "outcome": "disabled"},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("audit: got %v", recs)
+	}
+	for i := range want {
+		if _, ok := want[i]["plugin"]; !ok {
+			want[i]["plugin"] = "bort_test"
+		}
+		if recs[i]["msg"] != "command" {
+			t.Errorf("audit %d: got %v", i, recs[i])
+		}
+		for key, val := range want[i] {
+			if recs[i][key] != val {
+				t.Errorf("audit %d: %s: got %v, want %v", i, key, recs[i][key], val)
+			}
+		}
+		if _, ok := recs[i]["duration"]; !ok {
+			t.Errorf("audit %d: no duration", i)
+		}
+	}
+}
+
+func TestLogReload(t *testing.T) {
+	dir := t.TempDir()
+	oldFile, newFile := filepath.Join(dir, "old.log"), filepath.Join(dir, "new.log")
+	config := func(file string) string {
+		cfg, _ := json.Marshal(map[string]interface{}{"Log": map[string]string{"Format": "json", "File": file}})
+		return string(cfg)
+	}
+	h := borttest.New(t, config(oldFile))
+	if err := bort.InitLogging(); err != nil {
+		t.Fatal(err)
+	}
+	logger := bort.Logger()
+	logger.Info("before")
+	if err := h.Reload(config(newFile)); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("after")
+
+	if recs := readRecords(t, oldFile); len(recs) != 1 || recs[0]["msg"] != "before" {
+		t.Errorf("old log: got %v", recs)
+	}
+	if recs := readRecords(t, newFile); len(recs) != 1 || recs[0]["msg"] != "after" {
+		t.Errorf("new log: got %v", recs)
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	if err := (&bort.LogConfig{Level: "debug", Format: "json"}).Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, cfg := range []bort.LogConfig{{Level: "loud"}, {Format: "xml"}} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%+v: expected error", cfg)
+		}
+	}
+}
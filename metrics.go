@@ -0,0 +1,288 @@
+package bort
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram bucket upper bounds, in seconds, used if
+// none are given.  They suit handler latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Metrics are implemented here, rather than with client_golang, because every
+// plugin binary links this package, and client_golang would add its dependency
+// tree (protobuf, procfs, and the rest of the Prometheus libraries) to each of
+// them, for the counters, gauges, and histograms and the text format that are
+// all bort needs.
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	metrics    = map[string]*metric{}
+	metricsMut sync.Mutex // guards metrics
+)
+
+// core metrics
+var (
+	messagesIn      = NewCounter("bort_messages_in_total", "Incoming messages processed.")
+	messagesOut     = NewCounter("bort_messages_out_total", "Outgoing messages, by kind (reply or push).", "kind")
+	commandsTotal   = NewCounter("bort_commands_total", "Commands handled, by command and outcome.", "command", "outcome")
+	handlerSeconds  = NewHistogram("bort_handler_duration_seconds", "Time taken by handlers.", nil, "plugin", "handler")
+	handlerErrors   = NewCounter("bort_handler_errors_total", "Handler errors, timeouts, and panics.", "plugin", "handler", "outcome")
+	outboxDropped   = NewCounter("bort_outbox_dropped_total", "Pushed messages dropped or refused by a full outbox.", "plugin")
+	delayedFailures = NewCounter("bort_delayed_push_failures_total", "Failed deliveries of delayed pushes, including those retried.", "plugin")
+)
+
+// metric is a named family of time series, one for each combination of label
+// values.
+type metric struct {
+	name    string
+	help    string
+	kind    string // counter, gauge, or histogram
+	labels  []string
+	buckets []float64      // histograms only
+	fn      func() float64 // gauge funcs only
+
+	mut    sync.Mutex
+	series map[string]*series // by label values, joined
+}
+
+type series struct {
+	values []string
+	value  float64  // the sum, for histograms
+	counts []uint64 // histograms only, by bucket, not cumulative
+	count  uint64   // histograms only
+}
+
+// Counter is a metric that only increases, such as a number of messages.
+type Counter struct{ m *metric }
+
+// Gauge is a metric that may go up and down, such as a queue length.
+type Gauge struct{ m *metric }
+
+// Histogram is a metric that counts observations, such as durations, in
+// buckets.
+type Histogram struct{ m *metric }
+
+// NewCounter registers a counter with the given label names, and returns it.
+// Metric and label names must be valid Prometheus names.  Registering a name
+// again returns the existing metric if it has the same type and labels, and
+// panics otherwise, so metrics are best registered in package variables.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{registerMetric(&metric{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// NewGauge registers a gauge with the given label names, and returns it (see
+// NewCounter).
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{registerMetric(&metric{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// NewGaugeFunc registers a gauge without labels, whose value is returned by fn
+// whenever metrics are written (see NewCounter).
+func NewGaugeFunc(name, help string, fn func() float64) {
+	registerMetric(&metric{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, in
+// increasing order, and label names, and returns it (see NewCounter).  If
+// buckets is nil, DefaultBuckets are used.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metric %s: buckets not in increasing order", name))
+	}
+	return &Histogram{registerMetric(&metric{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+// Inc adds 1 to the counter's series with the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the counter's series with the
+// given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	c.m.update(values, func(s *series) { s.value += v })
+}
+
+// Set sets the gauge's series with the given label values to v.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.update(values, func(s *series) { s.value = v })
+}
+
+// Add adds v to the gauge's series with the given label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.m.update(values, func(s *series) { s.value += v })
+}
+
+// Observe records v in the histogram's series with the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.update(values, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.m.buckets))
+		}
+		if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.counts) {
+			s.counts[i]++
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+// registerMetric adds m to the registry, or returns the existing metric of the
+// same name.
+func registerMetric(m *metric) *metric {
+	metricsMut.Lock()
+	defer metricsMut.Unlock()
+
+	if old, ok := metrics[m.name]; ok {
+		if old.kind != m.kind || strings.Join(old.labels, ",") != strings.Join(m.labels, ",") ||
+			(old.fn == nil) != (m.fn == nil) {
+			panic(fmt.Sprintf("metric %s already registered differently", m.name))
+		}
+		return old
+	}
+	if !metricNameRE.MatchString(m.name) {
+		panic(fmt.Sprintf("invalid metric name '%s'", m.name))
+	}
+	for _, label := range m.labels {
+		if !labelNameRE.MatchString(label) || label == "le" {
+			panic(fmt.Sprintf("metric %s: invalid label name '%s'", m.name, label))
+		}
+	}
+	m.series = map[string]*series{}
+	metrics[m.name] = m
+	return m
+}
+
+// update applies fn to the series with the given label values, creating it if
+// need be.
+func (m *metric) update(values []string, fn func(s *series)) {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", m.name, len(values), len(m.labels)))
+	}
+	key := strings.Join(values, "\xff")
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		m.series[key] = s
+	}
+	fn(s)
+}
+
+// WriteMetrics writes the registered metrics to w in the Prometheus text
+// exposition format.  Metrics with labels are omitted until they have values.
+func WriteMetrics(w io.Writer) error {
+	metricsMut.Lock()
+	all := []*metric{}
+	for _, m := range metrics {
+		all = append(all, m)
+	}
+	metricsMut.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, m := range all {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// write writes the metric in the text exposition format.
+func (m *metric) write(w io.Writer) {
+	if m.fn != nil {
+		v := m.fn()
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+		fmt.Fprintf(w, "%s %s\n", m.name, formatValue(v))
+		return
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if len(m.series) == 0 && len(m.labels) > 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.kind)
+	if len(m.series) == 0 {
+		m.series[""] = &series{}
+	}
+	keys := []string{}
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.values, ""), formatValue(s.value))
+			continue
+		}
+		cum := uint64(0)
+		for i, bound := range m.buckets {
+			if s.counts != nil {
+				cum += s.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.values, formatValue(bound)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.values, ""), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.values, ""), s.count)
+	}
+}
+
+// formatLabels formats label names and values, adding an le label for
+// histogram buckets if not "".
+func formatLabels(names, values []string, le string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,137 @@
+package bort_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+// metricsText returns the metrics exposition, failing the test on error.
+func metricsText(t *testing.T) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := bort.WriteMetrics(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMetrics(t *testing.T) {
+	counter := bort.NewCounter("test_events_total", "Events.\nCounted.", "kind")
+	gauge := bort.NewGauge("test_level", "Level.")
+	hist := bort.NewHistogram("test_seconds", "Durations.", []float64{1, 5}, "op")
+	bort.NewGaugeFunc("test_answer", "The answer.", func() float64 { return 42 })
+
+	if got := metricsText(t); strings.Contains(got, "test_events_total") || !strings.Contains(got, "test_level 0\n") {
+		t.Errorf("before values:\n%s", got)
+	}
+	counter.Inc(`a"b`)
+	counter.Add(2, "c")
+	if again := bort.NewCounter("test_events_total", "Events.", "kind"); again == nil {
+		t.Error("re-registering: got nil")
+	}
+	bort.NewCounter("test_events_total", "", "kind").Inc("c")
+	gauge.Set(3)
+	gauge.Add(-1)
+	for _, v := range []float64{0.5, 1, 3, 10} {
+		hist.Observe(v, "get")
+	}
+
+	got := metricsText(t)
+	for _, want := range []string{
+		"# HELP test_events_total Events.\\nCounted.\n# TYPE test_events_total counter\n" +
+			"test_events_total{kind=\"a\\\"b\"} 1\ntest_events_total{kind=\"c\"} 3\n",
+		"# TYPE test_level gauge\ntest_level 2\n",
+		"# TYPE test_answer gauge\ntest_answer 42\n",
+		"# TYPE test_seconds histogram\n" +
+			"test_seconds_bucket{op=\"get\",le=\"1\"} 2\n" +
+			"test_seconds_bucket{op=\"get\",le=\"5\"} 3\n" +
+			"test_seconds_bucket{op=\"get\",le=\"+Inf\"} 4\n" +
+			"test_seconds_sum{op=\"get\"} 14.5\n" +
+			"test_seconds_count{op=\"get\"} 4\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing:\n%s\nin:\n%s", want, got)
+		}
+	}
+
+	for name, register := range map[string]func(){
+		"bad name":       func() { bort.NewCounter("test-bad", "") },
+		"reserved label": func() { bort.NewHistogram("test_le", "", nil, "le") },
+		"different type": func() { bort.NewGauge("test_events_total", "", "kind") },
+		"wrong labels":   func() { counter.Inc() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	h := borttest.New(t, "")
+	bort.RegisterCommand("metered", "succeed or fail", func(in, out *bort.Message) error {
+		if in.Args == "fail" {
+			return errors.New("failed")
+		}
+		out.Type = bort.PrivMsg
+		out.Text = "ok"
+		return nil
+	})
+	h.Command("metered", "")
+	h.Command("metered", "")
+	h.Command("metered", "fail")
+
+	got := metricsText(t)
+	for _, want := range []string{
+		`bort_commands_total{command="metered",outcome="ok"} 2`,
+		`bort_commands_total{command="metered",outcome="error"} 1`,
+		`bort_handler_errors_total{plugin="bort_test",handler="metered",outcome="error"} 1`,
+		`bort_handler_duration_seconds_count{plugin="bort_test",handler="metered"} 3`,
+		`bort_outbox_depth 0`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestMonitorHandler(t *testing.T) {
+	var readyErr error
+	srv := httptest.NewServer(bort.NewMonitorHandler(func() error { return readyErr }))
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if code, body := get("/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("healthz: got %d %q", code, body)
+	}
+	if code, body := get("/readyz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("ready: got %d %q", code, body)
+	}
+	readyErr = errors.New("not connected")
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || body != "not connected\n" {
+		t.Errorf("not ready: got %d %q", code, body)
+	}
+	if code, body := get("/metrics"); code != http.StatusOK || !strings.Contains(body, "# TYPE bort_messages_in_total counter") {
+		t.Errorf("metrics: got %d %q", code, body)
+	}
+}
@@ -0,0 +1,160 @@
+package bort
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+)
+
+// middlewares is guarded by regMut.
+var middlewares = map[string]*middleware{}
+
+// ProcessFunc processes an incoming message, appending any replies to msgs.
+type ProcessFunc func(in *Message, msgs *[]Message) error
+
+// Middleware wraps the processing of incoming messages.  It returns a
+// ProcessFunc that may inspect or modify the message before passing it to next,
+// drop it by not calling next, and inspect, modify, or drop the replies next
+// appends.  Only replies pass through middleware; messages pushed by plugins
+// don't.  Panics are recovered as for handlers: the panic is logged, the
+// message dropped, and middleware that panics too often disabled.
+type Middleware func(next ProcessFunc) ProcessFunc
+
+type middleware struct {
+	wrap   Middleware
+	plugin string
+	panicState
+}
+
+// core middleware
+func init() {
+	RegisterMiddleware("help", helpMiddleware)
+}
+
+// RegisterMiddleware registers middleware with the given name, to add
+// cross-cutting behavior such as ignore lists or rate limits.  Middleware may
+// modify or drop a message before passing it on, and modify or drop the
+// replies; pushed messages don't pass through it.  Middleware listed in the
+// Middleware configuration value runs in that order, outermost first, followed
+// by the rest in order of name.  The middleware belongs to the calling
+// package's plugin, and is skipped in channels where the plugin is disabled.
+// Panicking middleware is handled like a panicking handler (see
+// RegisterCommand).  The built-in help command is itself middleware, named
+// help.
+func RegisterMiddleware(name string, wrap Middleware) error {
+	plugin := callerPlugin()
+	regMut.Lock()
+	defer regMut.Unlock()
+
+	if name == "" {
+		return fmt.Errorf("cannot register empty middleware name")
+	}
+	if _, ok := middlewares[name]; ok {
+		return fmt.Errorf("%s: middleware already registered", name)
+	}
+	middlewares[name] = &middleware{wrap: wrap, plugin: plugin}
+	return nil
+}
+
+// UnregisterMiddleware unregisters the named middleware, if found, and returns
+// whether middleware was removed.
+func UnregisterMiddleware(name string) bool {
+	regMut.Lock()
+	defer regMut.Unlock()
+
+	_, ok := middlewares[name]
+	delete(middlewares, name)
+	return ok
+}
+
+// middlewareOrder returns the names of the registered middleware, in the
+// configured order.
+func middlewareOrder() []string {
+	core := channelSettings("").core
+
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	names := []string{}
+	listed := map[string]bool{}
+	for _, name := range core.Middleware {
+		if _, ok := middlewares[name]; ok && !listed[name] {
+			names = append(names, name)
+			listed[name] = true
+		}
+	}
+	rest := []string{}
+	for name := range middlewares {
+		if !listed[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// processChain returns the middleware chain, wrapping dispatch, for messages
+// in the context of plugins, as returned by channelPlugins.
+func processChain(plugins map[string]bool, res *dispatchResult) ProcessFunc {
+	names := middlewareOrder()
+	process := ProcessFunc(func(in *Message, msgs *[]Message) error {
+		return dispatch(plugins, in, msgs, res)
+	})
+
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	for i := len(names) - 1; i >= 0; i-- {
+		mw, ok := middlewares[names[i]]
+		if ok && pluginEnabled(plugins, mw.plugin) && !mw.isDisabled() {
+			process = mw.apply(names[i], process)
+		}
+	}
+	return process
+}
+
+// apply wraps next with the named middleware, recovering from its panics.  If
+// wrapping panics, the middleware is skipped.
+func (mw *middleware) apply(name string, next ProcessFunc) (process ProcessFunc) {
+	defer func() {
+		if r := recover(); r != nil {
+			mw.recovered(name, r)
+			process = next
+		}
+	}()
+	wrapped := mw.wrap(next)
+	return func(in *Message, msgs *[]Message) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				mw.recovered(name, r)
+				err = fmt.Errorf("%s: middleware panicked", name)
+			}
+		}()
+		return wrapped(in, msgs)
+	}
+}
+
+// recovered logs a panic by the named middleware, and disables it if it has
+// panicked panicLimit times within panicWindow.
+func (mw *middleware) recovered(name string, r interface{}) {
+	logger := pluginLogger(mw.plugin)
+	logger.Error("middleware panicked", "middleware", name,
+		"panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+	handlerErrors.Inc(mw.plugin, name, "panic")
+	if mw.panicked(Now()) {
+		logger.Warn("middleware disabled", "middleware", name, "panics", panicLimit)
+	}
+}
+
+// helpMiddleware replies privately to the help command with the commands of
+// enabled plugins.
+func helpMiddleware(next ProcessFunc) ProcessFunc {
+	return func(in *Message, msgs *[]Message) error {
+		if in.Command != "help" {
+			return next(in, msgs)
+		}
+		text := helpText(channelPlugins(in.Context))
+		*msgs = append(*msgs, Message{Type: PrivMsg, Context: in.Nick, Text: text})
+		return nil
+	}
+}
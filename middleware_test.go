@@ -0,0 +1,84 @@
+package bort_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestMiddleware(t *testing.T) {
+	h := borttest.New(t, `{"Middleware": ["ignore", "missing"]}`)
+	bort.RegisterCommand("echo", "echo arguments", echo)
+	trace := []string{}
+	bort.RegisterMiddleware("shout", func(next bort.ProcessFunc) bort.ProcessFunc {
+		return func(in *bort.Message, msgs *[]bort.Message) error {
+			trace = append(trace, "shout")
+			replies := []bort.Message{}
+			err := next(in, &replies)
+			for _, msg := range replies {
+				msg.Text = strings.ToUpper(msg.Text)
+				*msgs = append(*msgs, msg)
+			}
+			return err
+		}
+	})
+	bort.RegisterMiddleware("ignore", func(next bort.ProcessFunc) bort.ProcessFunc {
+		return func(in *bort.Message, msgs *[]bort.Message) error {
+			trace = append(trace, "ignore")
+			if in.Nick == "ignored" {
+				return nil
+			}
+			return next(in, msgs)
+		}
+	})
+	if err := bort.RegisterMiddleware("shout", nil); err == nil {
+		t.Error("expected error registering duplicate middleware")
+	}
+
+	msgs, err := h.Command("echo", "hi")
+	if got, want := borttest.Texts(msgs), []string{"HI"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+	if want := []string{"ignore", "shout"}; !reflect.DeepEqual(trace, want) {
+		t.Errorf("order: got %q, want %q", trace, want)
+	}
+	// help, not listed, runs after ignore, and before shout by name
+	if msgs, _ := h.Command("help", ""); len(msgs) != 1 || !strings.Contains(msgs[0].Text, "echo arguments") {
+		t.Errorf("help: got %q", borttest.Texts(msgs))
+	}
+
+	h.Nick = "ignored"
+	if msgs, _ := h.Command("help", ""); len(msgs) != 0 {
+		t.Errorf("ignored: got %q", borttest.Texts(msgs))
+	}
+	if !bort.UnregisterMiddleware("ignore") || bort.UnregisterMiddleware("ignore") {
+		t.Error("unregistering: expected only first to succeed")
+	}
+	if msgs, _ := h.Command("echo", "hi"); len(msgs) != 1 {
+		t.Errorf("after unregistering: got %q", borttest.Texts(msgs))
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	h := borttest.New(t, "")
+	bort.RegisterCommand("echo", "echo arguments", echo)
+	bort.RegisterMiddleware("fragile", func(next bort.ProcessFunc) bort.ProcessFunc {
+		return func(in *bort.Message, msgs *[]bort.Message) error {
+			if in.Args == "boom" {
+				panic("boom")
+			}
+			return next(in, msgs)
+		}
+	})
+	for i := 0; i < 3; i++ {
+		if msgs, err := h.Command("echo", "boom"); err == nil || len(msgs) != 0 {
+			t.Errorf("panic %d: got %q, %v", i+1, borttest.Texts(msgs), err)
+		}
+	}
+	if msgs, err := h.Command("echo", "boom"); err != nil || len(msgs) != 1 {
+		t.Errorf("disabled: got %q, %v", borttest.Texts(msgs), err)
+	}
+}
@@ -0,0 +1,57 @@
+package bort
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// MonitorHandler serves health checks and metrics, for monitoring systems such
+// as Prometheus and Kubernetes.  Endpoints are:
+//
+//	GET /healthz   replies "ok" while the process is running
+//	GET /readyz    replies "ok" if ready, or 503 Service Unavailable and the
+//	               reason if not
+//	GET /metrics   replies with the registered metrics in the Prometheus text
+//	               exposition format
+//
+// Handler latency, commands, errors, and outbox depth are measured, and plugins
+// may add metrics of their own with NewCounter, NewGauge, and NewHistogram.
+type MonitorHandler struct {
+	ready func() error
+	mux   *http.ServeMux
+}
+
+// NewMonitorHandler creates a MonitorHandler whose readiness is reported by
+// ready, which returns nil if ready, or the reason if not.
+func NewMonitorHandler(ready func() error) *MonitorHandler {
+	h := &MonitorHandler{ready: ready, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/healthz", h.healthz)
+	h.mux.HandleFunc("/readyz", h.readyz)
+	h.mux.HandleFunc("/metrics", h.metrics)
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *MonitorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *MonitorHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *MonitorHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := h.ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *MonitorHandler) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w)
+}
@@ -0,0 +1,244 @@
+package bort
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// outbox overflow policies
+const (
+	DropNewest = "drop-newest"
+	DropOldest = "drop-oldest"
+	Block      = "block"
+)
+
+const spoolBucket = "bort/outbox" // not a valid plugin name, so can't collide
+
+var errOutboxFull = errors.New("outbox full")
+
+// OutboxConfig holds the configurable values for the push queue, under the
+// Outbox key.  Overflow is the policy for pushes to a full queue: DropNewest
+// (the default) refuses the message, DropOldest discards the oldest queued
+// message, and Block waits up to BlockTimeout seconds for bort to pull.  Bort
+// doesn't pull while waiting for bortplug to process a message, so pushes made
+// then, such as from handlers, aren't blocked but refused as with DropNewest.
+// Quota, if not 0, limits the messages each plugin may have queued, with the
+// same policy applied when it is reached.  If Spool is set, queued messages
+// are kept in storage until pulled, so they survive restarts.
+type OutboxConfig struct {
+	Overflow     string
+	BlockTimeout uint
+	Quota        uint
+	Spool        bool
+}
+
+// Validate checks the overflow policy.
+func (c *OutboxConfig) Validate() error {
+	switch c.Overflow {
+	case "", DropNewest, DropOldest, Block:
+		return nil
+	}
+	return &FieldError{Path: []string{"Overflow"}, Err: fmt.Errorf("unknown policy '%s'", c.Overflow)}
+}
+
+// queued is a message in the push queue.
+type queued struct {
+	Plugin  string
+	Message Message
+	seq     uint64
+	spooled bool
+}
+
+// outboxQueue is the queue of pushed messages awaiting a pull.
+type outboxQueue struct {
+	mut    sync.Mutex
+	size   int
+	msgs   []*queued
+	counts map[string]int // by plugin
+	seq    uint64
+	pulled chan struct{} // closed when messages are pulled
+}
+
+func init() {
+	RegisterConfig("Outbox", &OutboxConfig{Overflow: DropNewest, BlockTimeout: 5})
+	NewGaugeFunc("bort_outbox_depth", "Pushed messages awaiting a pull.", func() float64 {
+		q := getOutbox()
+		if q == nil {
+			return 0
+		}
+		return float64(q.len())
+	})
+}
+
+// getOutbox returns the push queue, or nil before PluginInit.
+func getOutbox() *outboxQueue {
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	return outbox
+}
+
+func newOutbox(size uint) *outboxQueue {
+	return &outboxQueue{size: int(size), counts: map[string]int{}, pulled: make(chan struct{})}
+}
+
+// outboxConfig returns the push queue configuration.
+func outboxConfig() OutboxConfig {
+	cfg := struct{ Outbox OutboxConfig }{OutboxConfig{Overflow: DropNewest, BlockTimeout: 5}}
+	GetConfig(&cfg)
+	return cfg.Outbox
+}
+
+// push enqueues a message from a plugin, applying the overflow policy.
+func (q *outboxQueue) push(plugin string, msg *Message) error {
+	cfg := outboxConfig()
+	var deadline <-chan time.Time
+	if cfg.Overflow == Block {
+		deadline = After(time.Duration(cfg.BlockTimeout) * time.Second)
+	}
+
+	q.mut.Lock()
+	for {
+		full := len(q.msgs) >= q.size
+		overQuota := cfg.Quota > 0 && q.counts[plugin] >= int(cfg.Quota)
+		if !full && !overQuota {
+			break
+		}
+		switch cfg.Overflow {
+		case DropOldest:
+			victim := ""
+			if overQuota {
+				victim = plugin
+			}
+			if q.dropOldest(victim) {
+				continue
+			}
+			q.mut.Unlock()
+		case Block:
+			if processing.Load() > 0 {
+				q.mut.Unlock()
+				break // bort won't pull until processing is done
+			}
+			pulled := q.pulled
+			q.mut.Unlock()
+			select {
+			case <-pulled:
+				q.mut.Lock()
+				continue
+			case <-deadline:
+			}
+		default:
+			q.mut.Unlock()
+		}
+		outboxDropped.Inc(plugin)
+		if overQuota {
+			return fmt.Errorf("%s: outbox quota reached", plugin)
+		}
+		return errOutboxFull
+	}
+	defer q.mut.Unlock()
+
+	q.seq++
+	qm := &queued{Plugin: plugin, Message: *msg, seq: q.seq}
+	q.msgs = append(q.msgs, qm)
+	q.counts[plugin]++
+	if cfg.Spool {
+		if err := q.spool(qm); err != nil {
+			slog.Error("spooling message", "err", err)
+		}
+	}
+	return nil
+}
+
+// dropOldest discards the oldest queued message from plugin, or from any
+// plugin if plugin is "", and reports whether there was one.
+func (q *outboxQueue) dropOldest(plugin string) bool {
+	for i, qm := range q.msgs {
+		if plugin == "" || qm.Plugin == plugin {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			q.counts[qm.Plugin]--
+			q.unspool(qm)
+			outboxDropped.Inc(qm.Plugin)
+			pluginLogger(qm.Plugin).Warn("outbox full, dropped message")
+			return true
+		}
+	}
+	return false
+}
+
+// pull removes and returns the queued messages.
+func (q *outboxQueue) pull() []Message {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	msgs := []Message{}
+	for _, qm := range q.msgs {
+		msgs = append(msgs, qm.Message)
+		q.unspool(qm)
+	}
+	q.msgs = nil
+	q.counts = map[string]int{}
+	close(q.pulled)
+	q.pulled = make(chan struct{})
+	return msgs
+}
+
+// len returns the number of queued messages.
+func (q *outboxQueue) len() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return len(q.msgs)
+}
+
+func seqKey(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// spool saves a queued message in storage.
+func (q *outboxQueue) spool(qm *queued) error {
+	data, err := json.Marshal(qm)
+	if err != nil {
+		return err
+	}
+	if err := getStore().Put(spoolBucket, seqKey(qm.seq), data); err != nil {
+		return err
+	}
+	qm.spooled = true
+	return nil
+}
+
+// unspool removes a queued message from storage, if spooled.
+func (q *outboxQueue) unspool(qm *queued) {
+	if !qm.spooled {
+		return
+	}
+	if err := getStore().Delete(spoolBucket, seqKey(qm.seq)); err != nil {
+		slog.Error("unspooling message", "err", err)
+	}
+}
+
+// loadSpool queues the messages spooled before a restart.
+func (q *outboxQueue) loadSpool() error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return getStore().ForEach(spoolBucket, func(key string, value []byte) error {
+		qm := &queued{}
+		if err := json.Unmarshal(value, qm); err != nil {
+			return err
+		}
+		fmt.Sscan(key, &qm.seq)
+		qm.spooled = true
+		if qm.seq > q.seq {
+			q.seq = qm.seq
+		}
+		q.msgs = append(q.msgs, qm)
+		q.counts[qm.Plugin]++
+		return nil
+	})
+}
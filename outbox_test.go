@@ -0,0 +1,107 @@
+package bort_test
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+func pushN(n int) []error {
+	errs := []error{}
+	for i := 1; i <= n; i++ {
+		errs = append(errs, bort.Push(&bort.Message{Type: bort.PrivMsg, Text: strconv.Itoa(i)}))
+	}
+	return errs
+}
+
+func TestOutboxOverflow(t *testing.T) {
+	tests := []struct {
+		config string
+		want   []string
+		errs   int
+	}{
+		{`{}`, []string{"1", "2"}, 1},
+		{`{"Outbox": {"Overflow": "drop-oldest"}}`, []string{"2", "3"}, 0},
+		{`{"Outbox": {"Quota": 1}}`, []string{"1"}, 2},
+		{`{"Outbox": {"Overflow": "drop-oldest", "Quota": 1}}`, []string{"3"}, 0},
+	}
+	for _, test := range tests {
+		h := borttest.New(t, test.config)
+		bort.PluginInit(2)
+		errs := 0
+		for _, err := range pushN(3) {
+			if err != nil {
+				errs++
+			}
+		}
+		if got := borttest.Texts(h.Pushes()); !reflect.DeepEqual(got, test.want) || errs != test.errs {
+			t.Errorf("%s: got %q, %d errors, want %q, %d errors", test.config, got, errs, test.want, test.errs)
+		}
+	}
+
+	// a queue of size 0 would have nothing to drop, so is refused
+	h := borttest.New(t, `{"Outbox": {"Overflow": "drop-oldest"}}`)
+	if err := bort.PluginInit(0); err == nil {
+		t.Error("size 0: expected error")
+	}
+	pushN(1)
+	if got := borttest.Texts(h.Pushes()); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("size 0: got %q", got)
+	}
+}
+
+func TestOutboxBlock(t *testing.T) {
+	h := borttest.New(t, `{"Outbox": {"Overflow": "block", "BlockTimeout": 10}}`)
+	bort.PluginInit(1)
+	pushN(1)
+	done := make(chan error)
+	go func() { done <- bort.Push(&bort.Message{Type: bort.PrivMsg, Text: "2"}) }()
+	got := borttest.Texts(h.Pushes())
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, borttest.Texts(h.Pushes())...)
+	if want := []string{"1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// bort can't pull while processing, so handlers' pushes aren't blocked
+	bort.RegisterCommand("pushfull", "push to a full outbox", func(in, out *bort.Message) error {
+		return bort.Push(&bort.Message{Type: bort.PrivMsg, Text: "3"})
+	})
+	pushN(1)
+	if _, err := h.Command("pushfull", ""); err == nil || !strings.Contains(err.Error(), "outbox full") {
+		t.Errorf("push while processing: got %v", err)
+	}
+
+	go func() { done <- bort.Push(&bort.Message{Type: bort.PrivMsg, Text: "2"}) }()
+	for {
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("expected timeout")
+			}
+			return
+		case <-time.After(time.Millisecond):
+			h.Clock.Advance(time.Second)
+		}
+	}
+}
+
+func TestOutboxSpool(t *testing.T) {
+	h := borttest.New(t, `{"Outbox": {"Spool": true}}`)
+	pushN(2)
+	bort.PluginInit(borttest.OutboxSize) // restart
+	if got, want := borttest.Texts(h.Pushes()), []string{"1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	bort.PluginInit(borttest.OutboxSize)
+	if got := h.Pushes(); len(got) != 0 {
+		t.Errorf("got %d pushes after pull", len(got))
+	}
+}
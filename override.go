@@ -0,0 +1,290 @@
+package bort
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+const (
+	envPrefix  = "BORT_"
+	secretsKey = "$file"
+)
+
+// ApplyOverrides applies environment variable and secrets file overrides to
+// JSON configuration data, and returns the resulting data.
+//
+// Environment variables of the form BORT_KEY_SUBKEY=value set the value of a
+// key, or of a key in a nested object, such as BORT_NICK or
+// BORT_URLTITLE_TIMEOUT.  Keys are matched without regard to case, and
+// missing keys and objects are created.  Keys may contain underscores, as in
+// BORT_CHANNELCONFIG_#MY_CHAN_NICK: at each level, the longest key that is
+// declared by a registered section or already in the configuration is taken,
+// so a new key with an underscore, such as a channel not yet in ChannelConfig,
+// can't be set this way, but is taken to end at the first underscore.  A value for a key declared as a string
+// by a registered section (see RegisterConfig) is used verbatim.  Otherwise, a
+// value that is valid JSON, such as a number or list, is used as such, unless
+// it replaces an undeclared string value.  Variables naming a key that a
+// section doesn't declare, within a value that it does, such as
+// BORT_EXTERN_TEST, are ignored, so unrelated variables can't make the
+// configuration invalid (CheckConfig warns of them).  env is a list of
+// "key=value" strings, as returned by os.Environ.
+//
+// Then, any object of the form {"$file": "path"} is replaced by the contents
+// of the named file (less a trailing newline) as a string, so secrets such as
+// passwords can be kept separate from the configuration file.  Relative paths
+// are relative to dir.  Environment variables may also refer to secrets files
+// this way.
+func ApplyOverrides(data []byte, dir string, env []string) ([]byte, error) {
+	data, _, err := applyOverrides(data, dir, env)
+	return data, err
+}
+
+// applyOverrides applies overrides as ApplyOverrides does, and also returns
+// the names of the environment variables ignored.
+func applyOverrides(data []byte, dir string, env []string) ([]byte, []string, error) {
+	var root interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, nil, err
+	}
+	obj, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, nil, errors.New("configuration must be a JSON object")
+	}
+	cfgMut.RLock()
+	secs := append([]*section(nil), sections...)
+	cfgMut.RUnlock()
+
+	ignored := []string{}
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		keyVal := strings.SplitN(kv[len(envPrefix):], "=", 2)
+		if len(keyVal) != 2 || keyVal[0] == "" {
+			continue
+		}
+		path := envPath(secs, obj, keyVal[0])
+		typ, ok := declaredType(secs, path)
+		if !ok {
+			ignored = append(ignored, envPrefix+keyVal[0])
+			continue
+		}
+		if err := setPath(obj, path, typ, keyVal[1]); err != nil {
+			return nil, nil, fmt.Errorf("%s%s: %s", envPrefix, keyVal[0], err)
+		}
+	}
+	val, err := resolveSecrets(obj, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err = json.Marshal(val)
+	return data, ignored, err
+}
+
+// envPath splits the name of an environment variable, less the prefix, into
+// the path of keys it names, taking the longest key at each level that is
+// declared in secs or present in obj, or else the name up to an underscore.
+func envPath(secs []*section, obj map[string]interface{}, name string) []string {
+	path := []string{}
+	var val interface{} = obj
+	for {
+		sub, _ := val.(map[string]interface{})
+		keys := declaredKeys(secs, path)
+		for k := range sub {
+			keys = append(keys, k)
+		}
+		key := strings.SplitN(name, "_", 2)[0]
+		for _, k := range keys {
+			if len(k) > len(key) && strings.HasPrefix(strings.ToLower(name+"_"), strings.ToLower(k+"_")) {
+				key = name[:len(k)]
+			}
+		}
+		path = append(path, key)
+		if len(key) == len(name) {
+			return path
+		}
+		name = name[len(key)+1:]
+		val = sub[findKey(sub, key)]
+	}
+}
+
+// declaredKeys returns the keys declared in secs within the value at path.
+func declaredKeys(secs []*section, path []string) []string {
+	keys := []string{}
+	if len(path) == 0 {
+		for _, sec := range secs {
+			if sec.key != "" {
+				keys = append(keys, sec.key)
+			} else {
+				keys = append(keys, fieldKeys(sec.defaults.Type())...)
+			}
+		}
+		return keys
+	}
+	typ, ok := declaredType(secs, path)
+	if !ok || typ == nil {
+		return keys
+	}
+	return fieldKeys(typ)
+}
+
+// fieldKeys returns the keys of the fields of a struct type, as matched by
+// fieldByKey, or nil if typ isn't a struct.
+func fieldKeys(typ reflect.Type) []string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	keys := []string{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			keys = append(keys, fieldKeys(field.Type)...)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+// declaredType returns the type that the registered sections declare for the
+// value at path, or nil if they don't declare it.  It returns false if no
+// value may be at path, as it names a key that isn't declared within a value
+// that is.
+func declaredType(secs []*section, path []string) (reflect.Type, bool) {
+	for _, sec := range secs {
+		if sec.key != "" && strings.EqualFold(sec.key, path[0]) {
+			return typeAt(sec.defaults.Type(), path[1:])
+		}
+		if sec.key == "" {
+			if field, ok := fieldByKey(sec.defaults.Type(), path[0]); ok {
+				return typeAt(field.Type, path[1:])
+			}
+		}
+	}
+	return nil, true
+}
+
+// typeAt returns the type of the value at path within a value of type typ, as
+// declaredType does.
+func typeAt(typ reflect.Type, path []string) (reflect.Type, bool) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if len(path) == 0 {
+		if typ.Kind() == reflect.Interface {
+			return nil, true
+		}
+		return typ, true
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		field, ok := fieldByKey(typ, path[0])
+		if !ok {
+			return nil, false
+		}
+		return typeAt(field.Type, path[1:])
+	case reflect.Map:
+		return typeAt(typ.Elem(), path[1:])
+	case reflect.Interface:
+		return nil, true
+	}
+	return nil, false
+}
+
+// setPath sets the value at the path of keys in obj from an environment
+// variable value.  typ is the value's declared type, or nil if undeclared.
+func setPath(obj map[string]interface{}, path []string, typ reflect.Type, envVal string) error {
+	key := findKey(obj, path[0])
+	if len(path) > 1 {
+		sub, ok := obj[key].(map[string]interface{})
+		if !ok {
+			if _, exists := obj[key]; exists {
+				return fmt.Errorf("%s is not an object", key)
+			}
+			sub = map[string]interface{}{}
+			obj[key] = sub
+		}
+		return setPath(sub, path[1:], typ, envVal)
+	}
+	var val interface{}
+	dec := json.NewDecoder(strings.NewReader(envVal))
+	dec.UseNumber()
+	if err := dec.Decode(&val); err != nil || dec.More() {
+		val = envVal
+	}
+	if ref, ok := val.(map[string]interface{}); ok && ref[secretsKey] != nil {
+		obj[key] = val
+		return nil
+	}
+	_, isStr := obj[key].(string)
+	if typ != nil && typ.Kind() == reflect.String || typ == nil && isStr {
+		val = envVal
+	}
+	obj[key] = val
+	return nil
+}
+
+// findKey returns the key in obj that matches key without regard to case, or
+// key itself if there is none.
+func findKey(obj map[string]interface{}, key string) string {
+	for k := range obj {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+// resolveSecrets replaces secrets file references in val with the contents of
+// the files.
+func resolveSecrets(val interface{}, dir string) (interface{}, error) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if path, ok := v[secretsKey].(string); ok && len(v) == 1 {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			secret, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return strings.TrimSuffix(strings.TrimSuffix(string(secret), "\n"), "\r"), nil
+		}
+		for key := range v {
+			res, err := resolveSecrets(v[key], dir)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = res
+		}
+	case []interface{}:
+		for i := range v {
+			res, err := resolveSecrets(v[i], dir)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = res
+		}
+	}
+	return val, nil
+}
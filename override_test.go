@@ -0,0 +1,113 @@
+package bort_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ianremmler/bort"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data := `{
+		"Nick": "bort",
+		"PollPeriod": 5,
+		"Urltitle": {"Prefix": "> "},
+		"Password": {"$file": "secret"}
+	}`
+	env := []string{
+		"HOME=/home/bort",
+		"BORT_NICK=1234",
+		"BORT_POLLPERIOD=10",
+		"BORT_URLTITLE_TIMEOUT=3",
+		"BORT_CHANNELS=[\"#a\", \"#b\"]",
+		"BORT_FLIP_FLIPPER=flip ",
+		`BORT_KEY={"$file": "` + filepath.Join(dir, "secret") + `"}`,
+	}
+	out, err := bort.ApplyOverrides([]byte(data), dir, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	json.Unmarshal(out, &got)
+	want := map[string]interface{}{
+		"Nick":       "1234",
+		"PollPeriod": 10.0,
+		"Urltitle":   map[string]interface{}{"Prefix": "> ", "TIMEOUT": 3.0},
+		"Password":   "hunter2",
+		"CHANNELS":   []interface{}{"#a", "#b"},
+		"FLIP":       map[string]interface{}{"FLIPPER": "flip "},
+		"KEY":        "hunter2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	if _, err := bort.ApplyOverrides([]byte(data), dir, []string{"BORT_NICK_FIRST=x"}); err == nil {
+		t.Error("expected error setting key in non-object")
+	}
+	if _, err := bort.ApplyOverrides([]byte(`{"Key": {"$file": "missing"}}`), dir, nil); err == nil {
+		t.Error("expected error for missing secrets file")
+	}
+}
+
+func TestApplyOverridesDeclared(t *testing.T) {
+	env := []string{
+		"BORT_LOG_FILE=1234",
+		"BORT_LOG_TEST_HELPER=1",
+		"BORT_HANDLERTIMEOUT=7",
+		"BORT_ADMINS_FIRST=x",
+		"BORT_CHANNELCONFIG_#A_NICK=1234",
+	}
+	out, err := bort.ApplyOverrides([]byte(`{}`), "", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	json.Unmarshal(out, &got)
+	want := map[string]interface{}{
+		"LOG":            map[string]interface{}{"FILE": "1234"},
+		"HANDLERTIMEOUT": 7.0,
+		"CHANNELCONFIG":  map[string]interface{}{"#A": map[string]interface{}{"NICK": 1234.0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestApplyOverridesUnderscores(t *testing.T) {
+	defer bort.Isolate()()
+	bort.RegisterConfig("Under", &struct {
+		MaxLen int `json:"max_len"`
+	}{})
+
+	data := `{"ChannelConfig": {"#my_chan": {"Nick": "a"}}}`
+	env := []string{
+		"BORT_UNDER_MAX_LEN=3",
+		"BORT_CHANNELCONFIG_#MY_CHAN_NICK=b",
+		"BORT_CHANNELCONFIG_#NEW_CHAN_NICK=c",
+	}
+	out, err := bort.ApplyOverrides([]byte(data), "", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	json.Unmarshal(out, &got)
+	want := map[string]interface{}{
+		"UNDER": map[string]interface{}{"MAX_LEN": 3.0},
+		"ChannelConfig": map[string]interface{}{
+			"#my_chan": map[string]interface{}{"Nick": "b"},
+			// new keys end at the first underscore
+			"#NEW": map[string]interface{}{"CHAN": map[string]interface{}{"NICK": "c"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
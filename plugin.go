@@ -2,21 +2,30 @@ package bort
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"os"
 	"regexp"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"text/tabwriter"
+	"time"
+
+	"github.com/ianremmler/bort/ircfmt"
 )
 
 var (
-	outbox     chan Message
+	outbox     *outboxQueue
 	setupFuncs = []SetupFunc{}
 	commands   = map[string]*command{}
 	matchers   = []*matcher{}
 	matcherID  uint64
-	help       string
+	regMut     sync.RWMutex // guards commands, matchers, matcherID, middlewares, and outbox
+	processing atomic.Int64 // Process calls in progress
 )
 
 // SetupFunc provides a means for plugins to initialize themselves
@@ -26,15 +35,88 @@ type SetupFunc func() error
 // handling, and to retrieve pending push messages.
 type Plugin struct{}
 
-// Process inspects and processes an incoming message.
+// Process inspects and processes an incoming message, passing it through the
+// middleware chain to the command and match handlers.  IRC formatting is
+// stripped from the message's text, which is kept in Raw, and from replies to
+// channels configured with NoColor.  Commands are recorded in the audit log,
+// whether handled by a plugin or middleware, disabled, or dropped.
 func (p *Plugin) Process(in *Message, msgs *[]Message) error { // rpc
-	if in.Command == "help" {
-		*msgs = append(*msgs, Message{Type: PrivMsg, Context: in.Nick, Text: help})
-		return nil
+	processing.Add(1)
+	defer processing.Add(-1)
+
+	start := time.Now()
+	if in.Raw == "" {
+		in.Raw = in.Text
+	}
+	in.Text, in.Args = ircfmt.Strip(in.Text), ircfmt.Strip(in.Args)
+	messagesIn.Inc()
+	orig := *in
+	replies := []Message{}
+	res := &dispatchResult{}
+	err := processChain(channelPlugins(in.Context), res)(in, &replies)
+	for i := range replies {
+		formatOut(&replies[i])
+	}
+	messagesOut.Add(float64(len(replies)), "reply")
+	*msgs = append(*msgs, replies...)
+	res.audit(&orig, len(replies) > 0, err, time.Since(start))
+	return err
+}
+
+// dispatchResult describes the handling of a message passed to dispatch, for
+// the audit log.
+type dispatchResult struct {
+	in      *Message // as dispatched, nil if it never reached dispatch
+	plugin  string   // the command's plugin, if it had a handler
+	outcome string   // the command's outcome, if it had a handler
+	err     error
+}
+
+// audit records a registered command in the audit log.  If it didn't reach
+// dispatch, the plugin is "middleware", and the outcome "error" if processing
+// failed, "ok" if there were replies, as from help, or else "dropped".
+func (res *dispatchResult) audit(orig *Message, replied bool, err error, d time.Duration) {
+	switch {
+	case res.outcome != "":
+		audit(res.in, res.plugin, res.outcome, res.err, d)
+	case orig.Command == "" || res.in != nil:
+		// not a command, or not a registered one
+	case err != nil:
+		audit(orig, "middleware", "error", err, d)
+	case replied:
+		audit(orig, "middleware", "ok", nil, d)
+	default:
+		audit(orig, "middleware", "dropped", nil, d)
 	}
-	if cmd, ok := commands[in.Command]; ok {
-		out := Message{Context: in.Context}
-		if err := cmd.handle(in, &out); err != nil {
+}
+
+// formatOut strips colors from an outgoing message if its context is
+// configured with NoColor.
+func formatOut(msg *Message) {
+	if channelSettings(msg.Context).core.NoColor {
+		msg.Text = ircfmt.StripColors(msg.Text)
+	}
+}
+
+// dispatch passes an incoming message to the handler of its command, if
+// registered, or else to the matching match handlers, of the enabled plugins.
+// The handling of the command, if any, is recorded in res.
+func dispatch(plugins map[string]bool, in *Message, msgs *[]Message, res *dispatchResult) error {
+	regMut.RLock()
+	cmd, ok := commands[in.Command]
+	matchs := append([]*matcher(nil), matchers...)
+	regMut.RUnlock()
+	inCopy := *in
+	res.in = &inCopy
+	if ok {
+		res.plugin = cmd.plugin
+		if !pluginEnabled(plugins, cmd.plugin) || cmd.isDisabled() {
+			res.outcome = "disabled"
+			return nil
+		}
+		out, outcome, err := cmd.run(in)
+		res.outcome, res.err = outcome, err
+		if err != nil {
 			return err
 		}
 		if out.Type != None {
@@ -43,8 +125,8 @@ func (p *Plugin) Process(in *Message, msgs *[]Message) error { // rpc
 		return nil
 	}
 	errs := ""
-	for _, match := range matchers {
-		if match.types&in.Type == 0 {
+	for _, match := range matchs {
+		if match.types&in.Type == 0 || !pluginEnabled(plugins, match.plugin) || match.isDisabled() {
 			continue
 		}
 		matches := match.re.FindStringSubmatch(in.Text)
@@ -56,8 +138,8 @@ func (p *Plugin) Process(in *Message, msgs *[]Message) error { // rpc
 			idx = 1
 		}
 		in.Match = matches[idx]
-		out := Message{Context: in.Context}
-		if err := match.handle(in, &out); err != nil {
+		out, _, err := match.run(in)
+		if err != nil {
 			errs += fmt.Sprintln(err)
 			continue
 		}
@@ -73,33 +155,59 @@ func (p *Plugin) Process(in *Message, msgs *[]Message) error { // rpc
 
 // Pull fetches queued messages pushed by plugins.
 func (p *Plugin) Pull(dummy struct{}, msgs *[]Message) error { // rpc
-	n := len(outbox)
-	for i := 0; i < n; i++ {
-		*msgs = append(*msgs, <-outbox)
+	attach()
+	pushed := getOutbox().pull()
+	messagesOut.Add(float64(len(pushed)), "push")
+	*msgs = append(*msgs, pushed...)
+	return nil
+}
+
+// Commands lists the names of registered commands, so bort can route commands
+// to the bortplug instance that handles them.
+func (p *Plugin) Commands(dummy struct{}, cmds *[]string) error { // rpc
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	for cmd := range commands {
+		*cmds = append(*cmds, cmd)
 	}
+	sort.Strings(*cmds)
 	return nil
 }
 
-// Push enqueues an outgoing message pushed by a plugin.
+// Plugins lists the names of the plugins that have registered handlers, so bort
+// can route plugin admin commands to the bortplug instances that have them.
+func (p *Plugin) Plugins(dummy struct{}, plugins *[]string) error { // rpc
+	*plugins = append(*plugins, pluginNames()...)
+	return nil
+}
+
+// Push enqueues an outgoing message pushed by a plugin.  Messages from plugins
+// disabled in the message's context are refused.
 func Push(msg *Message) error {
-	select {
-	case outbox <- *msg:
-		return nil
-	default:
-		return errors.New("outbox full")
+	return push(callerPlugin(), msg)
+}
+
+// push enqueues an outgoing message from the named plugin.
+func push(plugin string, msg *Message) error {
+	if !PluginEnabled(plugin, msg.Context) {
+		return fmt.Errorf("%s: plugin disabled in %s", plugin, msg.Context)
 	}
+	out := *msg
+	formatOut(&out)
+	return getOutbox().push(plugin, &out)
 }
 
 type command struct {
-	handle HandleFunc
-	help   string
+	handler
+	help string
 }
 
 type matcher struct {
-	id     uint64
-	types  MessageType
-	re     *regexp.Regexp
-	handle HandleFunc
+	handler
+	id    uint64
+	types MessageType
+	re    *regexp.Regexp
 }
 
 // RegisterSetup registers a function to be run once bort has connected and
@@ -109,21 +217,47 @@ func RegisterSetup(fn SetupFunc) {
 }
 
 // RegisterCommand registers a command handler for the given name.  help is a
-// one line description of the plugin's purpose.
+// one line description of the plugin's purpose.  The handler belongs to the
+// calling package's plugin, so it can be disabled with it.  A panicking
+// handler doesn't bring down bortplug: the panic is logged with a stack trace
+// and a generic error replied, and a handler that panics three times within
+// ten minutes, even after its deadline, is disabled until it is registered
+// again or bortplug restarts.
 func RegisterCommand(cmd, help string, handle HandleFunc) error {
+	return registerCommand(callerPlugin(), cmd, help, handle.withContext())
+}
+
+// RegisterCommandContext registers a command handler that takes a context,
+// like RegisterCommand.  Handlers have a deadline, 10 seconds unless set by the
+// HandlerTimeout configuration value, or per command (or per plugin, for
+// matchers) by the HandlerTimeouts value, such as {"forecast": 30}.  A handler
+// still running at its deadline is abandoned and the timeout reported, and the
+// context is canceled so it can stop early.
+func RegisterCommandContext(cmd, help string, handle ContextHandleFunc) error {
+	return registerCommand(callerPlugin(), cmd, help, handle)
+}
+
+// registerCommand registers a command handler for the named plugin.
+func registerCommand(plugin, cmd, help string, handle ContextHandleFunc) error {
+	regMut.Lock()
+	defer regMut.Unlock()
+
 	if cmd == "" {
 		return errors.New("cannot register empty command name")
 	}
 	if _, ok := commands[cmd]; ok {
 		return fmt.Errorf("%s: command already registered", cmd)
 	}
-	commands[cmd] = &command{help: help, handle: handle}
+	commands[cmd] = &command{handler: handler{handle: handle, plugin: plugin, cmd: cmd}, help: help}
 	return nil
 }
 
 // UnregisterCommand unrigesters the command handler for the given name, if
 // found, and returns whether a handler was removed.
 func UnregisterCommand(cmd string) bool {
+	regMut.Lock()
+	defer regMut.Unlock()
+
 	_, ok := commands[cmd]
 	if ok {
 		delete(commands, cmd)
@@ -134,14 +268,29 @@ func UnregisterCommand(cmd string) bool {
 // RegisterMatcher registers a match handler for the given regular expression.
 // types is a bitmask that specifies which message types to consider.  The text
 // matched (or that of the first capturing group, if any) will be placed in the
-// Match field of the message passed to handle.
+// Match field of the message passed to handle.  The handler belongs to the
+// calling package's plugin, so it can be disabled with it.
 func RegisterMatcher(types MessageType, match string, handle HandleFunc) (uint64, error) {
+	return registerMatcher(callerPlugin(), types, match, handle.withContext())
+}
+
+// RegisterMatcherContext registers a match handler that takes a context, like
+// RegisterMatcher.
+func RegisterMatcherContext(types MessageType, match string, handle ContextHandleFunc) (uint64, error) {
+	return registerMatcher(callerPlugin(), types, match, handle)
+}
+
+// registerMatcher registers a match handler for the named plugin.
+func registerMatcher(plugin string, types MessageType, match string, handle ContextHandleFunc) (uint64, error) {
 	re, err := regexp.Compile(match)
 	if err != nil {
 		return 0, err
 	}
+	regMut.Lock()
+	defer regMut.Unlock()
+
 	matcherID++
-	m := &matcher{id: matcherID, types: types, re: re, handle: handle}
+	m := &matcher{handler: handler{handle: handle, plugin: plugin}, id: matcherID, types: types, re: re}
 	matchers = append(matchers, m)
 	return matcherID, nil
 }
@@ -149,6 +298,9 @@ func RegisterMatcher(types MessageType, match string, handle HandleFunc) (uint64
 // UnregisterMatcher unrigesters the match handler for the given ID, if found,
 // and returns whether a handler was removed.
 func UnregisterMatcher(id uint64) bool {
+	regMut.Lock()
+	defer regMut.Unlock()
+
 	for i := range matchers {
 		if matchers[i].id == id {
 			matchers = append(matchers[:i], matchers[i+1:]...)
@@ -158,28 +310,183 @@ func UnregisterMatcher(id uint64) bool {
 	return false
 }
 
-// PluginInit calls plugin setup functions, sets up the push queue, and
-// generates plugin help text.
-func PluginInit(outboxSize uint) {
-	outbox = make(chan Message, outboxSize)
+// PluginInit opens storage, calls plugin setup functions, sets up the push
+// queue, and starts running scheduled jobs and delayed pushes.  It returns an
+// error, without setting up plugins, if storage can't be opened, such as when
+// another bortplug has it open, or if outboxSize is 0.
+func PluginInit(outboxSize uint) error {
+	if outboxSize == 0 {
+		return errors.New("outbox size must be positive")
+	}
+	q := newOutbox(outboxSize)
+	regMut.Lock()
+	outbox = q
+	regMut.Unlock()
+	if err := loadPluginState(); err != nil {
+		slog.Error("loading plugin state", "err", err)
+	}
+	if err := openStore(); err != nil {
+		return fmt.Errorf("opening storage: %s", err)
+	}
+	if err := q.loadSpool(); err != nil {
+		slog.Error("loading spooled messages", "err", err)
+	}
+	if err := loadDelayed(); err != nil {
+		slog.Error("loading delayed pushes", "err", err)
+	}
 
 	for _, fn := range setupFuncs {
 		if err := fn(); err != nil {
-			log.Println(err)
+			slog.Error("plugin setup", "err", err)
 		}
 	}
 	setupFuncs = nil
+	startScheduler()
+	return nil
+}
+
+// PluginShutdown cancels the contexts of running handlers, stops running
+// scheduled jobs and delayed pushes, and closes storage.  It is called when
+// bortplug exits.
+func PluginShutdown() {
+	regMut.Lock()
+	stopHandlers()
+	regMut.Unlock()
+
+	schedMut.Lock()
+	if schedQuit != nil {
+		close(schedQuit)
+		schedQuit = nil
+	}
+	schedMut.Unlock()
+
+	storeMut.Lock()
+	defer storeMut.Unlock()
+
+	if store != nil {
+		store.Close()
+		store = nil
+	}
+}
+
+// Isolate replaces the plugin registry, scheduled jobs, push queues,
+// configuration, clock, and logging with copies, and storage with an empty
+// memory store.  It returns a function that stops the copies' scheduler,
+// cancels their handlers, closes the store and log files, and restores the
+// originals.  It is intended for tests, so each can run plugin setup afresh on
+// a registry populated by plugins' init functions.
+func Isolate() (restore func()) {
+	regMut.Lock()
+	defer regMut.Unlock()
+	cfgMut.Lock()
+	defer cfgMut.Unlock()
+	schedMut.Lock()
+	defer schedMut.Unlock()
+	storeMut.Lock()
+	defer storeMut.Unlock()
+	clockMut.Lock()
+	defer clockMut.Unlock()
+	logMut.Lock()
+	defer logMut.Unlock()
+
+	origSetupFuncs, origCommands, origMatchers := setupFuncs, commands, matchers
+	origMiddlewares := middlewares
+	origMatcherID, origOutbox, origConfigData, origClock := matcherID, outbox, configData, clock
+	origChanConfigs := chanConfigs
+	origConfigFile, origAdmins, origReloadFuncs := configFile, admins, reloadFuncs
+	origSections, origPluginState := sections, pluginState
+	origJobs, origLastPull, origSchedQuit := jobs, lastPull, schedQuit
+	origDelayed, origDelayedID, origStore := delayed, delayedID, store
+	origHandlerCtx, origStopHandlers := handlerCtx, stopHandlers
+	origLogger, origLogStarted, origLogFiles, origAuditLog := slog.Default(), logStarted, logFiles, auditLog
+	origLogOut, origAuditOut := logOut, auditOut
+
+	setupFuncs = append([]SetupFunc(nil), setupFuncs...)
+	commands = map[string]*command{}
+	for name, cmd := range origCommands {
+		commands[name] = cmd
+	}
+	matchers = append([]*matcher(nil), matchers...)
+	middlewares = map[string]*middleware{}
+	for name, mw := range origMiddlewares {
+		middlewares[name] = mw
+	}
+	configData = append([]byte(nil), configData...)
+	reloadFuncs = append([]ReloadFunc(nil), reloadFuncs...)
+	sections = append([]*section(nil), sections...)
+	pluginState = map[string]map[string]bool{}
+	jobs = map[string]*job{}
+	for name, j := range origJobs {
+		jCopy := *j
+		jobs[name] = &jCopy
+	}
+	lastPull, schedQuit = time.Time{}, nil
+	delayed, delayedID = map[uint64]*delayedPush{}, 0
+	store = NewMemoryStore()
+	handlerCtx, stopHandlers = context.WithCancel(context.Background())
+	logStarted, logFiles, auditLog = false, nil, nil
+	logOut, auditOut = &logWriter{w: os.Stderr}, &logWriter{w: io.Discard}
+
+	return func() {
+		regMut.Lock()
+		defer regMut.Unlock()
+		cfgMut.Lock()
+		defer cfgMut.Unlock()
+		schedMut.Lock()
+		defer schedMut.Unlock()
+		storeMut.Lock()
+		defer storeMut.Unlock()
+		clockMut.Lock()
+		defer clockMut.Unlock()
+		logMut.Lock()
+		defer logMut.Unlock()
+
+		if schedQuit != nil {
+			close(schedQuit)
+		}
+		stopHandlers()
+		if store != nil {
+			store.Close()
+		}
+		logOut.set(io.Discard)
+		auditOut.set(io.Discard)
+		for _, f := range logFiles {
+			f.Close()
+		}
+		setupFuncs, commands, matchers = origSetupFuncs, origCommands, origMatchers
+		middlewares = origMiddlewares
+		matcherID, outbox, configData, clock = origMatcherID, origOutbox, origConfigData, origClock
+		chanConfigs = origChanConfigs
+		configFile, admins, reloadFuncs = origConfigFile, origAdmins, origReloadFuncs
+		sections, pluginState = origSections, origPluginState
+		jobs, lastPull, schedQuit = origJobs, origLastPull, origSchedQuit
+		delayed, delayedID, store = origDelayed, origDelayedID, origStore
+		handlerCtx, stopHandlers = origHandlerCtx, origStopHandlers
+		logStarted, logFiles, auditLog = origLogStarted, origLogFiles, origAuditLog
+		logOut, auditOut = origLogOut, origAuditOut
+		slog.SetDefault(origLogger)
+	}
+}
+
+// helpText generates help text listing the registered commands of enabled
+// plugins.  It is generated on request, so it includes commands registered
+// after setup.
+func helpText(plugins map[string]bool) string {
+	regMut.RLock()
+	defer regMut.RUnlock()
 
 	buf := &bytes.Buffer{}
 	tabWrite := tabwriter.NewWriter(buf, 2, 0, 1, ' ', 0)
 	cmds := sort.StringSlice{}
-	for cmd := range commands {
-		cmds = append(cmds, cmd)
+	for name, cmd := range commands {
+		if pluginEnabled(plugins, cmd.plugin) && !cmd.isDisabled() {
+			cmds = append(cmds, name)
+		}
 	}
 	cmds.Sort()
 	for _, cmd := range cmds {
 		fmt.Fprintf(tabWrite, "%s:\t%s\n", cmd, commands[cmd].help)
 	}
 	tabWrite.Flush()
-	help = buf.String()
+	return buf.String()
 }
@@ -0,0 +1,60 @@
+package calc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestHelp(t *testing.T) {
+	h := borttest.New(t, "")
+	msgs, err := h.Command("calc", "help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Text != helpStr {
+		t.Fatalf("got %q, want help text", borttest.Texts(msgs))
+	}
+	if msgs[0].Context != h.Nick {
+		t.Errorf("help sent to %s, want %s", msgs[0].Context, h.Nick)
+	}
+}
+
+func TestCalc(t *testing.T) {
+	h := borttest.New(t, "")
+	tests := []struct {
+		args, want string
+	}{
+		{"1 2 +", "3"},
+		{"7 2 -", "5"},
+		{"6 7 *", "42"},
+		{"1 4 /", "0.25"},
+		{"7 2 div", "3"},
+		{"2 10 ^", "1024"},
+		{"5 !", "120"},
+		{"1 2 3", "3 2 1"},
+		{"hex 255", "0xff"},
+	}
+	for _, test := range tests {
+		msgs, err := h.Command("calc", test.args)
+		if err != nil {
+			t.Errorf("%s: %s", test.args, err)
+			continue
+		}
+		// results are padded to a fixed width, so compare only the values
+		if len(msgs) != 1 || strings.Join(strings.Fields(msgs[0].Text), " ") != test.want {
+			t.Errorf("%s: got %q, want %q", test.args, borttest.Texts(msgs), test.want)
+		}
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	h := borttest.New(t, "")
+	if _, err := h.Command("calc", "1 bogus"); err == nil {
+		t.Error("expected error for invalid input")
+	}
+	if _, err := h.Command("calc", ""); err == nil {
+		t.Error("expected error for empty stack")
+	}
+}
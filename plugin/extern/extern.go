@@ -0,0 +1,388 @@
+// Package extern is a bort IRC bot plugin that hosts plugins implemented as
+// external executables, which may be written in any language.
+//
+// extern looks for a pair at the top level of the bort configuration file whose
+// key is "extern" and value is an object with a list of plugins to run:
+//
+//	"Extern": {
+//		"Plugins": [
+//			{"Name": "weather", "Path": "/usr/local/bin/weather.py"}
+//		]
+//	}
+//
+// Each plugin is started with its optional Args and Dir, and communicates
+// with bortplug by exchanging frames of newline-delimited JSON over its stdin
+// and stdout.  Its stderr is passed on to bortplug's log.  A plugin that exits
+// is restarted after a delay that grows while it keeps failing.
+//
+// A frame is an object with a Type and type-specific fields.  Messages are
+// encoded as JSON objects with the fields of bort.Message.  On startup, a
+// plugin registers its handlers, then signals that it is ready:
+//
+//	{"Type": "command", "Name": "weather", "Help": "current weather"}
+//	{"Type": "matcher", "Name": "bugs", "Match": "bug #(\\d+)", "Types": 2}
+//	{"Type": "ready"}
+//
+// Types is a bort.MessageType bitmask, and defaults to bort.PrivMsg.  When a
+// registered handler is triggered, extern sends a handle frame with a unique
+// ID, and the plugin must answer with a reply frame with the same ID,
+// containing the outgoing message (if any) or an error:
+//
+//	{"Type": "handle", "ID": 7, "Name": "weather", "Message": {...}}
+//	{"Type": "reply", "ID": 7, "Message": {"Type": 2, "Text": "sunny"}}
+//	{"Type": "reply", "ID": 8, "Error": "no such bug"}
+//
+// A plugin may push a message at any time:
+//
+//	{"Type": "push", "Message": {"Type": 2, "Context": "#bort", "Text": "hi"}}
+//
+// Commands registered by external plugins are listed in help like any other.
+package extern
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ianremmler/bort"
+)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+var (
+	cfg atomic.Pointer[Config] // replaced on reload
+
+	errNotRunning = errors.New("plugin not running")
+	errTimeout    = errors.New("plugin timed out")
+)
+
+// Config holds the configurable values for the plugin.  StartTimeout and
+// Timeout are the seconds to wait for a plugin to become ready and to reply to
+// a message, respectively.  A reply is awaited no longer than the handler
+// deadline, so Timeout only matters if it is the shorter.
+type Config struct {
+	Plugins      []PluginConfig
+	StartTimeout uint
+	Timeout      uint
+}
+
+// Validate checks that plugins have paths and timeouts are set.
+func (c *Config) Validate() error {
+	errs := []error{}
+	for i := range c.Plugins {
+		if c.Plugins[i].Path == "" {
+			path := []string{"Plugins", fmt.Sprint(i), "Path"}
+			errs = append(errs, &bort.FieldError{Path: path, Err: errors.New("missing path")})
+		}
+	}
+	if c.StartTimeout == 0 {
+		errs = append(errs, &bort.FieldError{Path: []string{"StartTimeout"}, Err: errors.New("must be positive")})
+	}
+	if c.Timeout == 0 {
+		errs = append(errs, &bort.FieldError{Path: []string{"Timeout"}, Err: errors.New("must be positive")})
+	}
+	return errors.Join(errs...)
+}
+
+// PluginConfig describes an external plugin executable.
+type PluginConfig struct {
+	Name string
+	Path string
+	Args []string
+	Dir  string
+}
+
+// frame is a unit of the protocol spoken with external plugins.
+type frame struct {
+	Type    string
+	ID      uint64           `json:",omitempty"`
+	Name    string           `json:",omitempty"`
+	Help    string           `json:",omitempty"`
+	Match   string           `json:",omitempty"`
+	Types   bort.MessageType `json:",omitempty"`
+	Message *bort.Message    `json:",omitempty"`
+	Error   string           `json:",omitempty"`
+}
+
+// plugin supervises a running external plugin.
+type plugin struct {
+	PluginConfig
+
+	mut      sync.Mutex
+	enc      *json.Encoder
+	lastID   uint64
+	pending  map[uint64]chan *frame
+	handlers map[string]bool
+	ready    chan struct{}
+}
+
+func newPlugin(pc PluginConfig) *plugin {
+	if pc.Name == "" {
+		pc.Name = pc.Path
+	}
+	return &plugin{
+		PluginConfig: pc,
+		handlers:     map[string]bool{},
+		ready:        make(chan struct{}),
+	}
+}
+
+// supervise runs the plugin, restarting it whenever it exits.
+func (p *plugin) supervise() {
+	delay := minRestartDelay
+	for {
+		start := time.Now()
+		if err := p.run(); err != nil {
+			p.logger().Error("plugin failed", "err", err)
+		} else {
+			p.logger().Warn("plugin exited")
+		}
+		if time.Since(start) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// run starts the plugin and processes its output until it exits.
+func (p *plugin) run() error {
+	cmd := exec.Command(p.Path, p.Args...)
+	cmd.Dir = p.Dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go p.logStderr(stderr)
+
+	p.mut.Lock()
+	p.enc = json.NewEncoder(stdin)
+	p.pending = map[uint64]chan *frame{}
+	p.mut.Unlock()
+
+	dec := json.NewDecoder(stdout)
+	for {
+		fr := &frame{}
+		if err := dec.Decode(fr); err != nil {
+			if err != io.EOF {
+				p.logger().Error("decoding frame", "err", err)
+			}
+			break
+		}
+		p.receive(fr)
+	}
+
+	p.mut.Lock()
+	p.enc = nil
+	for _, reply := range p.pending {
+		close(reply)
+	}
+	p.pending = nil
+	p.mut.Unlock()
+
+	stdin.Close()
+	return cmd.Wait()
+}
+
+// receive handles a frame sent by the plugin.
+func (p *plugin) receive(fr *frame) {
+	switch fr.Type {
+	case "command":
+		p.registerCommand(fr.Name, fr.Help)
+	case "matcher":
+		p.registerMatcher(fr.Name, fr.Match, fr.Types)
+	case "ready":
+		select {
+		case <-p.ready:
+		default:
+			close(p.ready)
+		}
+	case "reply":
+		p.mut.Lock()
+		reply, ok := p.pending[fr.ID]
+		delete(p.pending, fr.ID)
+		p.mut.Unlock()
+		if ok {
+			reply <- fr
+		}
+	case "push":
+		if fr.Message == nil {
+			break
+		}
+		if err := bort.Push(fr.Message); err != nil {
+			p.logger().Error("pushing message", "err", err)
+		}
+	default:
+		p.logger().Warn("unknown frame type", "type", fr.Type)
+	}
+}
+
+// registerCommand registers a command handled by the plugin.  Handlers
+// registered before a restart are kept, so they are not registered again.
+func (p *plugin) registerCommand(name, help string) {
+	if p.handlers[name] {
+		return
+	}
+	if err := bort.RegisterCommandContext(name, help, p.handler(name)); err != nil {
+		p.logger().Error("registering command", "command", name, "err", err)
+		return
+	}
+	p.handlers[name] = true
+}
+
+// registerMatcher registers a matcher handled by the plugin.
+func (p *plugin) registerMatcher(name, match string, types bort.MessageType) {
+	if p.handlers[name] {
+		return
+	}
+	if types == bort.None {
+		types = bort.PrivMsg
+	}
+	if _, err := bort.RegisterMatcherContext(types, match, p.handler(name)); err != nil {
+		p.logger().Error("registering matcher", "matcher", name, "err", err)
+		return
+	}
+	p.handlers[name] = true
+}
+
+// handler returns a function that has the plugin handle messages for the
+// named command or matcher.  It waits for the reply until the handler's
+// deadline or the Timeout, whichever comes first.
+func (p *plugin) handler(name string) bort.ContextHandleFunc {
+	return func(ctx context.Context, in, out *bort.Message) error {
+		reply := make(chan *frame, 1)
+		p.mut.Lock()
+		if p.enc == nil {
+			p.mut.Unlock()
+			return fmt.Errorf("%s: %s", p.Name, errNotRunning)
+		}
+		p.lastID++
+		id := p.lastID
+		p.pending[id] = reply
+		err := p.enc.Encode(&frame{Type: "handle", ID: id, Name: name, Message: in})
+		p.mut.Unlock()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case fr, ok := <-reply:
+			if !ok {
+				return fmt.Errorf("%s: %s", p.Name, errNotRunning)
+			}
+			if fr.Error != "" {
+				return errors.New(fr.Error)
+			}
+			if fr.Message != nil {
+				out.Type = fr.Message.Type
+				out.Text = fr.Message.Text
+				if fr.Message.Context != "" {
+					out.Context = fr.Message.Context
+				}
+			}
+			return nil
+		case <-ctx.Done():
+			p.forget(id)
+			return fmt.Errorf("%s: %s", p.Name, ctx.Err())
+		case <-time.After(time.Duration(cfg.Load().Timeout) * time.Second):
+			p.forget(id)
+			return fmt.Errorf("%s: %s", p.Name, errTimeout)
+		}
+	}
+}
+
+// forget stops waiting for the reply to the message with the given ID.
+func (p *plugin) forget(id uint64) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	delete(p.pending, id)
+}
+
+// logStderr passes lines the plugin writes to stderr on to the log.
+func (p *plugin) logStderr(stderr io.Reader) {
+	scan := bufio.NewScanner(stderr)
+	for scan.Scan() {
+		p.logger().Info(scan.Text())
+	}
+}
+
+// logger returns a logger that identifies the plugin.
+func (p *plugin) logger() *slog.Logger {
+	return bort.Logger().With("extern", p.Name)
+}
+
+// reload applies new timeouts.  Changes to the list of plugins require a
+// restart.
+func reload(old, new bort.Config) error {
+	newCfg := defaultConfig()
+	if err := new.Decode(&struct{ Extern *Config }{newCfg}); err != nil {
+		return err
+	}
+	if err := newCfg.Validate(); err != nil {
+		return err
+	}
+	c := *cfg.Load()
+	c.StartTimeout, c.Timeout = newCfg.StartTimeout, newCfg.Timeout
+	cfg.Store(&c)
+	return nil
+}
+
+func setup() error {
+	bort.RegisterReload(reload)
+	c := defaultConfig()
+	if err := bort.GetConfig(&struct{ Extern *Config }{c}); err != nil {
+		return err
+	}
+	cfg.Store(c)
+	plugins := []*plugin{}
+	for _, pc := range c.Plugins {
+		p := newPlugin(pc)
+		plugins = append(plugins, p)
+		go p.supervise()
+	}
+	// give plugins a chance to register their handlers before bort asks
+	deadline := time.After(time.Duration(c.StartTimeout) * time.Second)
+	for _, p := range plugins {
+		select {
+		case <-p.ready:
+		case <-deadline:
+			return errors.New("extern: timed out waiting for plugins to start")
+		}
+	}
+	return nil
+}
+
+func defaultConfig() *Config {
+	return &Config{StartTimeout: 5, Timeout: 10}
+}
+
+func init() {
+	cfg.Store(defaultConfig())
+	bort.RegisterConfig("Extern", defaultConfig())
+	bort.RegisterSetup(setup)
+}
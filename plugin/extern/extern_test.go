@@ -0,0 +1,102 @@
+package extern
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+const helperEnv = "BORT_EXTERN_TEST_HELPER"
+
+// TestHelperPlugin isn't a real test.  It acts as an external plugin when run
+// by TestExtern.
+func TestHelperPlugin(t *testing.T) {
+	if os.Getenv(helperEnv) != "1" {
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.Encode(&frame{Type: "command", Name: "shout", Help: "shout text"})
+	enc.Encode(&frame{Type: "matcher", Name: "bug", Match: `bug #(\d+)`})
+	enc.Encode(&frame{Type: "ready"})
+	dec := json.NewDecoder(os.Stdin)
+	for {
+		fr := &frame{}
+		if dec.Decode(fr) != nil {
+			os.Exit(0)
+		}
+		reply := &frame{Type: "reply", ID: fr.ID}
+		switch fr.Name {
+		case "shout":
+			if fr.Message.Args == "" {
+				reply.Error = "nothing to shout"
+				break
+			}
+			enc.Encode(&frame{Type: "push", Message: &bort.Message{Type: bort.PrivMsg, Text: "shouted"}})
+			reply.Message = &bort.Message{Type: bort.PrivMsg, Text: strings.ToUpper(fr.Message.Args)}
+		case "bug":
+			reply.Message = &bort.Message{Type: bort.PrivMsg, Text: "bug " + fr.Message.Match}
+		}
+		enc.Encode(reply)
+	}
+}
+
+func TestExtern(t *testing.T) {
+	t.Setenv(helperEnv, "1")
+	cfgData, _ := json.Marshal(map[string]interface{}{
+		"Extern": Config{
+			StartTimeout: 10,
+			Timeout:      10,
+			Plugins: []PluginConfig{{
+				Name: "helper",
+				Path: os.Args[0],
+				Args: []string{"-test.run=^TestHelperPlugin$"},
+			}},
+		},
+	})
+	h := borttest.New(t, string(cfgData))
+
+	msgs, err := h.Command("shout", "hey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts := borttest.Texts(msgs); len(texts) != 1 || texts[0] != "HEY" {
+		t.Errorf("shout: got %q, want HEY", texts)
+	}
+	if _, err := h.Command("shout", ""); err == nil || err.Error() != "nothing to shout" {
+		t.Errorf("shout: got error %v", err)
+	}
+	msgs, err = h.Say("fixes bug #12")
+	if texts := borttest.Texts(msgs); err != nil || len(texts) != 1 || texts[0] != "bug 12" {
+		t.Errorf("bug: got %q, %v", texts, err)
+	}
+	msgs, _ = h.Command("help", "")
+	if texts := borttest.Texts(msgs); len(texts) != 1 || !strings.Contains(texts[0], "shout") {
+		t.Errorf("help: got %q", texts)
+	}
+	if texts := borttest.Texts(h.Pushes()); len(texts) != 1 || texts[0] != "shouted" {
+		t.Errorf("pushes: got %q", texts)
+	}
+}
+
+func TestHandlerCanceled(t *testing.T) {
+	cfg.Store(defaultConfig())
+	p := newPlugin(PluginConfig{Name: "stalled"})
+	p.enc = json.NewEncoder(io.Discard)
+	p.pending = map[uint64]chan *frame{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.handler("stall")(ctx, &bort.Message{}, &bort.Message{})
+	if err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Errorf("got %v, want canceled", err)
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("%d replies still pending", len(p.pending))
+	}
+}
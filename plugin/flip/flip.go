@@ -4,6 +4,7 @@ package flip
 
 import (
 	"strings"
+	"sync/atomic"
 
 	"github.com/ianremmler/bort"
 )
@@ -49,10 +50,8 @@ var flipTable = map[rune]rune{
 	'"':  '„',
 }
 
-var cfg = &Config{
-	Flipper: defaultFlipper,
-	Chiller: defaultChiller,
-}
+// cfg is the global configuration, replaced on reload.
+var cfg atomic.Pointer[Config]
 
 type Config struct {
 	Flipper string
@@ -63,6 +62,8 @@ func init() {
 	for k, v := range flipTable {
 		flipTable[v] = k
 	}
+	cfg.Store(defaultConfig())
+	bort.RegisterConfig("Flip", defaultConfig())
 	bort.RegisterSetup(setup)
 	bort.RegisterCommand("flip", "flip text (or tables by default)", Flip)
 	bort.RegisterCommand("chill", "unflip text (or tables by default)", Chill)
@@ -78,7 +79,7 @@ func Flip(in, out *bort.Message) error {
 		flipped = tableUp
 	}
 	out.Type = bort.PrivMsg
-	out.Text = cfg.Flipper + flipped
+	out.Text = channelConfig(in.Context).Flipper + flipped
 	return nil
 }
 
@@ -92,7 +93,7 @@ func Chill(in, out *bort.Message) error {
 		text = tableDown
 	}
 	out.Type = bort.PrivMsg
-	out.Text = text + cfg.Chiller
+	out.Text = text + channelConfig(in.Context).Chiller
 	return nil
 }
 
@@ -110,6 +111,35 @@ func flip(text string) string {
 	return out
 }
 
+func defaultConfig() *Config {
+	return &Config{Flipper: defaultFlipper, Chiller: defaultChiller}
+}
+
+// channelConfig returns the configuration for a channel, falling back to the
+// global configuration.
+func channelConfig(channel string) *Config {
+	c := defaultConfig()
+	if err := bort.GetChannelConfig(channel, &struct{ Flip *Config }{c}); err != nil {
+		return cfg.Load()
+	}
+	return c
+}
+
 func setup() error {
-	return bort.GetConfig(&struct{ Flip *Config }{cfg})
+	bort.RegisterReload(reload)
+	c := defaultConfig()
+	if err := bort.GetConfig(&struct{ Flip *Config }{c}); err != nil {
+		return err
+	}
+	cfg.Store(c)
+	return nil
+}
+
+func reload(old, new bort.Config) error {
+	newCfg := defaultConfig()
+	if err := new.Decode(&struct{ Flip *Config }{newCfg}); err != nil {
+		return err
+	}
+	cfg.Store(newCfg)
+	return nil
 }
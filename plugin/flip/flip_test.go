@@ -0,0 +1,40 @@
+package flip
+
+import (
+	"testing"
+
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestFlipAndChill(t *testing.T) {
+	h := borttest.New(t, "")
+	tests := []struct {
+		cmd, args, want string
+	}{
+		{"flip", "", defaultFlipper + tableUp},
+		{"flip", "Hello", defaultFlipper + "oʃʃǝɥ"},
+		{"chill", "", tableDown + defaultChiller},
+		{"chill", "hello", "hello" + defaultChiller},
+	}
+	for _, test := range tests {
+		msgs, err := h.Command(test.cmd, test.args)
+		if err != nil {
+			t.Errorf("%s %s: %s", test.cmd, test.args, err)
+			continue
+		}
+		if len(msgs) != 1 || msgs[0].Text != test.want {
+			t.Errorf("%s %s: got %q, want %q", test.cmd, test.args, borttest.Texts(msgs), test.want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	t.Cleanup(func() { cfg.Store(defaultConfig()) })
+	h := borttest.New(t, `{"Flip": {"Flipper": "flip ", "Chiller": " chill"}}`)
+	if msgs, _ := h.Command("flip", ""); len(msgs) != 1 || msgs[0].Text != "flip "+tableUp {
+		t.Errorf("flip: got %q", borttest.Texts(msgs))
+	}
+	if msgs, _ := h.Command("chill", ""); len(msgs) != 1 || msgs[0].Text != tableDown+" chill" {
+		t.Errorf("chill: got %q", borttest.Texts(msgs))
+	}
+}
@@ -3,16 +3,18 @@
 package forecast
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
-	"regexp"
+	"net/url"
 	"time"
 
 	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/ircfmt"
 	"github.com/jteeuwen/go-pkg-xmlx"
 	"golang.org/x/net/html/charset"
 )
@@ -28,6 +30,9 @@ const (
 var (
 	errFc  = errors.New("Error retrieving forecast.")
 	errLoc = errors.New("I had a problem finding that location.")
+
+	// the location is the whole argument text, such as "O'Fallon, MO"
+	forecastArgs = []bort.Arg{{Name: "location", Type: bort.RestArg}}
 )
 
 type location struct {
@@ -38,9 +43,9 @@ type location struct {
 
 // Forecast returns a pretty-printed forecast for the given location.  The
 // location may be anything understood by OpenStreetMap's Nominatim service.
-func Forecast(in, out *bort.Message) error {
-	loc := regexp.MustCompile("\\s+").ReplaceAllLiteralString(in.Args, "+")
-	outp, err := http.Get(fmt.Sprintf(locURLFmt, loc))
+func Forecast(ctx context.Context, in, out *bort.Message, args *bort.Args) error {
+	loc := url.QueryEscape(args.String("location"))
+	outp, err := get(ctx, fmt.Sprintf(locURLFmt, loc))
 	if err != nil {
 		return errLoc
 	}
@@ -54,7 +59,7 @@ func Forecast(in, out *bort.Message) error {
 	if len(locs) == 0 {
 		return errLoc
 	}
-	fc, err := forecast(locs[0])
+	fc, err := forecast(ctx, locs[0])
 	if err != nil {
 		return errFc
 	}
@@ -63,10 +68,23 @@ func Forecast(in, out *bort.Message) error {
 	return nil
 }
 
-func forecast(loc location) (string, error) {
+// get fetches uri, giving up when ctx is canceled.
+func get(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func forecast(ctx context.Context, loc location) (string, error) {
+	resp, err := get(ctx, fmt.Sprintf(fcURLFmt, loc.Lat, loc.Lon))
+	if err != nil {
+		return "", errFc
+	}
+	defer resp.Body.Close()
 	doc := xmlx.New()
-	url := fmt.Sprintf(fcURLFmt, loc.Lat, loc.Lon)
-	err := doc.LoadUri(url, func(str string, rdr io.Reader) (io.Reader, error) {
+	err = doc.LoadStream(resp.Body, func(str string, rdr io.Reader) (io.Reader, error) {
 		return charset.NewReader(rdr, str)
 	})
 	if err != nil {
@@ -109,7 +127,12 @@ func forecast(loc location) (string, error) {
 	precipRange := fmt.Sprintf("%3d %3d", minPrecip, maxPrecip)
 	speedRange := fmt.Sprintf("%3d %3d", minSpeed, maxSpeed)
 
-	out := fmt.Sprintf("Forecast for %s\n", loc.Name)
+	tempGraph = ircfmt.Colored(ircfmt.Red, tempGraph)
+	humidGraph = ircfmt.Colored(ircfmt.Green, humidGraph)
+	precipGraph = ircfmt.Colored(ircfmt.Blue, precipGraph)
+	speedGraph = ircfmt.Colored(ircfmt.Orange, speedGraph)
+
+	out := fmt.Sprintf("Forecast for %s\n", ircfmt.Bold(loc.Name))
 	out += fmt.Sprintf("         min max %-24s%24s\n", start, end)
 	out += fmt.Sprintf("Temp °F  %7s %s\n", tempRange, tempGraph)
 	out += fmt.Sprintf("Humid %%  %7s %s\n", humidRange, humidGraph) // esc % 2X for later fmt use
@@ -175,11 +198,11 @@ func makeGraph(vals []int) (int, int, string) {
 	min, max := minmax(vals)
 	for _, val := range vals {
 		octile := rescale(val, min, max, 8)
-		graph += string(firstOctile + octile)
+		graph += string(rune(firstOctile + octile))
 	}
 	return min, max, graph
 }
 
 func init() {
-	bort.RegisterCommand("forecast", "asciitastic 2 day NWS forecast for a given location", Forecast)
+	bort.RegisterCommandArgs("forecast", "asciitastic 2 day NWS forecast for a given location", forecastArgs, Forecast)
 }
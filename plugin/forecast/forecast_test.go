@@ -0,0 +1,40 @@
+package forecast
+
+import (
+	"testing"
+
+	"github.com/ianremmler/bort"
+)
+
+func TestArgs(t *testing.T) {
+	for _, loc := range []string{"O'Fallon, MO", "-33.9,151.2", `"Paris" Texas`} {
+		args, err := bort.ParseArgs(forecastArgs, loc)
+		if err != nil {
+			t.Errorf("%s: %s", loc, err)
+		} else if got := args.String("location"); got != loc {
+			t.Errorf("%s: got %q", loc, got)
+		}
+	}
+}
+
+func TestDirIndex(t *testing.T) {
+	tests := map[int]int{0: 0, 20: 0, 25: 1, 90: 2, 180: 4, 270: 6, 350: 0}
+	for dir, want := range tests {
+		if got := dirIndex(dir); got != want {
+			t.Errorf("dirIndex(%d) = %d, want %d", dir, got, want)
+		}
+	}
+}
+
+func TestMakeGraph(t *testing.T) {
+	min, max, graph := makeGraph([]int{10, 45, 80, 80})
+	if min != 10 || max != 80 {
+		t.Errorf("got range %d-%d, want 10-80", min, max)
+	}
+	if want := "▁▅██"; graph != want {
+		t.Errorf("got graph %q, want %q", graph, want)
+	}
+	if _, _, graph := makeGraph(nil); graph != "" {
+		t.Errorf("got graph %q for no values", graph)
+	}
+}
@@ -3,42 +3,100 @@
 //
 // heckle looks for a pair at the top level of the bort configuration file
 // whose key is "heckle" and value is an object that consists of watch/retort
-// pairs.
+// pairs.  Channels may have additional or different retorts in their
+// ChannelConfig sections, and a watch given a null retort is ignored there.
 package heckle
 
 import (
-	"log"
+	"errors"
+	"regexp"
 	"strings"
 
 	"github.com/ianremmler/bort"
 )
 
-var (
-	retorts = retortMap{}
-)
+var matcherIDs = []uint64{}
 
 type retortMap map[string]string
 
-func responder(retort string) bort.HandleFunc {
+type config struct {
+	Retorts retortMap
+}
+
+// Validate checks that watches are valid regular expressions.
+func (c *config) Validate() error {
+	errs := []error{}
+	for watch := range c.Retorts {
+		if _, err := regexp.Compile(watch); err != nil {
+			errs = append(errs, &bort.FieldError{Path: []string{"Retorts", watch}, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// responder returns a handler that responds to a watch with the retort
+// configured for the message's channel, if any.
+func responder(watch string) bort.HandleFunc {
 	return func(in, out *bort.Message) error {
+		c := &config{}
+		if err := bort.GetChannelConfig(in.Context, c); err != nil {
+			return err
+		}
+		retort, ok := c.Retorts[watch]
+		if !ok {
+			return nil
+		}
 		out.Type = bort.PrivMsg
 		out.Text = strings.Replace(retort, "%m", in.Match, -1)
 		return nil
 	}
 }
 
-func setup() error {
-	if err := bort.GetConfig(&struct{ Retorts retortMap }{retorts}); err != nil {
+// watches lists the watches configured globally or for any channel.
+func watches() (map[string]bool, error) {
+	all := map[string]bool{}
+	for _, ch := range append([]string{""}, bort.ConfigChannels()...) {
+		c := &config{}
+		if err := bort.GetChannelConfig(ch, c); err != nil {
+			return nil, err
+		}
+		for watch := range c.Retorts {
+			all[watch] = true
+		}
+	}
+	return all, nil
+}
+
+func register() error {
+	all, err := watches()
+	if err != nil {
 		return err
 	}
-	for watch, retort := range retorts {
-		if _, err := bort.RegisterMatcher(bort.PrivMsg, watch, responder(retort)); err != nil {
-			log.Println(err)
+	for watch := range all {
+		id, err := bort.RegisterMatcher(bort.PrivMsg, watch, responder(watch))
+		if err != nil {
+			bort.Logger().Error("registering watch", "watch", watch, "err", err)
+			continue
 		}
+		matcherIDs = append(matcherIDs, id)
 	}
 	return nil
 }
 
+func setup() error {
+	bort.RegisterReload(reload)
+	return register()
+}
+
+func reload(old, new bort.Config) error {
+	for _, id := range matcherIDs {
+		bort.UnregisterMatcher(id)
+	}
+	matcherIDs = nil
+	return register()
+}
+
 func init() {
+	bort.RegisterConfig("", &config{})
 	bort.RegisterSetup(setup)
 }
@@ -0,0 +1,70 @@
+package heckle
+
+import (
+	"testing"
+
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestHeckle(t *testing.T) {
+	h := borttest.New(t, `{"Retorts": {"hello (\\w+)": "hi %m", "^ping$": "pong"}}`)
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"hello world", []string{"hi world"}},
+		{"ping", []string{"pong"}},
+		{"ping pong", []string{}},
+	}
+	for _, test := range tests {
+		msgs, err := h.Say(test.text)
+		if err != nil {
+			t.Errorf("%s: %s", test.text, err)
+			continue
+		}
+		got := borttest.Texts(msgs)
+		if len(got) != len(test.want) || (len(got) > 0 && got[0] != test.want[0]) {
+			t.Errorf("%s: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	h := borttest.New(t, `{"Retorts": {"^ping$": "pong"}}`)
+	if err := h.Reload(`{"Retorts": {"^ping$": "pong!"}}`); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := h.Say("ping")
+	if got := borttest.Texts(msgs); err != nil || len(got) != 1 || got[0] != "pong!" {
+		t.Errorf("got %q, %v, want [pong!]", got, err)
+	}
+}
+
+func TestChannelConfig(t *testing.T) {
+	h := borttest.New(t, `{
+		"Retorts": {"^ping$": "pong", "^hi$": "hello"},
+		"ChannelConfig": {
+			"#quiet": {"Plugins": {"heckle": false}},
+			"#other": {"Retorts": {"^ping$": "PONG", "^hi$": null, "^yo$": "sup"}}
+		}
+	}`)
+	tests := []struct {
+		channel, text string
+		want          []string
+	}{
+		{"#test", "ping", []string{"pong"}},
+		{"#test", "yo", []string{}},
+		{"#quiet", "ping", []string{}},
+		{"#other", "ping", []string{"PONG"}},
+		{"#other", "hi", []string{}},
+		{"#other", "yo", []string{"sup"}},
+	}
+	for _, test := range tests {
+		h.Channel = test.channel
+		msgs, err := h.Say(test.text)
+		got := borttest.Texts(msgs)
+		if err != nil || len(got) != len(test.want) || (len(got) > 0 && got[0] != test.want[0]) {
+			t.Errorf("%s %s: got %q, %v, want %q", test.channel, test.text, got, err, test.want)
+		}
+	}
+}
@@ -3,9 +3,10 @@
 package urltitle
 
 import (
-	"log"
+	"context"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ianremmler/bort"
@@ -14,8 +15,8 @@ import (
 )
 
 var (
-	cfg    = &Config{Timeout: 5}
-	client = &http.Client{}
+	cfg    atomic.Pointer[Config] // the global configuration, replaced on reload
+	client = &http.Client{}       // timeouts are set per request, by channel
 )
 
 type Config struct {
@@ -38,8 +39,18 @@ func findNode(node *html.Node, path ...string) *html.Node {
 	return node
 }
 
-func extractTitle(in, out *bort.Message) error {
-	resp, err := client.Get(in.Match)
+func extractTitle(ctx context.Context, in, out *bort.Message) error {
+	c := channelConfig(in.Context)
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.Timeout)*time.Second)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", in.Match, nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil
 	}
@@ -56,28 +67,51 @@ func extractTitle(in, out *bort.Message) error {
 		out.Type = bort.PrivMsg
 		text := strings.TrimSpace(title.FirstChild.Data)
 		text = strings.SplitN(text, "\n", 2)[0] // first line
-		out.Text = cfg.Prefix + text + cfg.Suffix
+		out.Text = c.Prefix + text + c.Suffix
 	}
 	return nil
 }
 
+// channelConfig returns the configuration for a channel, falling back to the
+// global configuration.
+func channelConfig(channel string) *Config {
+	c := &Config{Timeout: 5}
+	if err := bort.GetChannelConfig(channel, &struct{ Urltitle *Config }{c}); err != nil {
+		return cfg.Load()
+	}
+	return c
+}
+
 func setup() error {
-	if err := bort.GetConfig(&struct{ Urltitle *Config }{cfg}); err != nil {
+	bort.RegisterReload(reload)
+	c := &Config{Timeout: 5}
+	if err := bort.GetConfig(&struct{ Urltitle *Config }{c}); err != nil {
+		return err
+	}
+	cfg.Store(c)
+	return nil
+}
+
+func reload(old, new bort.Config) error {
+	newCfg := &Config{Timeout: 5}
+	if err := new.Decode(&struct{ Urltitle *Config }{newCfg}); err != nil {
 		return err
 	}
-	client.Timeout = time.Duration(cfg.Timeout) * time.Second
+	cfg.Store(newCfg)
 	return nil
 }
 
 func init() {
+	cfg.Store(&Config{Timeout: 5})
+	bort.RegisterConfig("Urltitle", &Config{Timeout: 5})
 	bort.RegisterSetup(setup)
 	urlRE, err := xurls.StrictMatchingScheme("http")
 	if err != nil {
-		log.Println("urltitle: error setting regexp")
+		bort.Logger().Error("error setting regexp", "err", err)
 		return
 	}
 	pat := "(" + urlRE.String() + ")"
-	if _, err = bort.RegisterMatcher(bort.PrivMsg, pat, extractTitle); err != nil {
-		log.Println("urltitle: error registering plugin")
+	if _, err = bort.RegisterMatcherContext(bort.PrivMsg, pat, extractTitle); err != nil {
+		bort.Logger().Error("error registering plugin", "err", err)
 	}
 }
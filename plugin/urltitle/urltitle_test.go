@@ -0,0 +1,53 @@
+package urltitle
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestExtractTitle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "<html><head><title>\n  A Page\nsecond line</title></head></html>")
+	}))
+	defer srv.Close()
+
+	h := borttest.New(t, `{"Urltitle": {"Prefix": "[ ", "Suffix": " ]"}}`)
+	msgs, err := h.Say("look at " + srv.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Text != "[ A Page ]" {
+		t.Errorf("got %q, want %q", borttest.Texts(msgs), "[ A Page ]")
+	}
+
+	msgs, err = h.Say("look at " + srv.URL + "/missing")
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("missing page: got %q, %v", borttest.Texts(msgs), err)
+	}
+}
+
+func TestChannelTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		fmt.Fprint(w, "<html><head><title>Slow</title></head></html>")
+	}))
+	defer srv.Close()
+
+	h := borttest.New(t, `{"Urltitle": {"Timeout": 1}, "ChannelConfig": {"#patient": {"Urltitle": {"Timeout": 3}}}}`)
+	if msgs, _ := h.Say(srv.URL); len(msgs) != 0 {
+		t.Errorf("global timeout: got %q", borttest.Texts(msgs))
+	}
+	h.Channel = "#patient"
+	if msgs, _ := h.Say(srv.URL); len(msgs) != 1 || msgs[0].Text != "Slow" {
+		t.Errorf("channel timeout: got %q, want Slow", borttest.Texts(msgs))
+	}
+}
@@ -0,0 +1,317 @@
+package bort_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+	"github.com/ianremmler/bort/ircfmt"
+)
+
+func echo(in, out *bort.Message) error {
+	out.Type = bort.PrivMsg
+	out.Text = in.Args + in.Match
+	return nil
+}
+
+func TestProcess(t *testing.T) {
+	h := borttest.New(t, "")
+	bort.RegisterCommand("echo", "echo arguments", echo)
+	bort.RegisterCommand("fail", "always fail", func(in, out *bort.Message) error {
+		return errors.New("failed")
+	})
+	bort.RegisterMatcher(bort.PrivMsg, `\bfoo\b`, echo)
+	bort.RegisterMatcher(bort.PrivMsg|bort.Action, `bar(\d+)`, echo)
+
+	tests := []struct {
+		in   bort.Message
+		want []string
+	}{
+		{bort.Message{Type: bort.PrivMsg, Command: "echo", Args: "foo bar1"}, []string{"foo bar1"}},
+		{bort.Message{Type: bort.PrivMsg, Text: "foo bar2"}, []string{"foo", "2"}},
+		{bort.Message{Type: bort.Action, Text: "foo bar3"}, []string{"3"}},
+		{bort.Message{Type: bort.Join, Text: "foo bar4"}, []string{}},
+	}
+	for _, test := range tests {
+		msgs, err := h.Send(&test.in)
+		if err != nil {
+			t.Errorf("%+v: %s", test.in, err)
+			continue
+		}
+		if got := borttest.Texts(msgs); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got %q, want %q", test.in, got, test.want)
+		}
+	}
+	if _, err := h.Command("fail", ""); err == nil {
+		t.Error("fail: expected error")
+	}
+
+	msgs, _ := h.Command("help", "")
+	if len(msgs) != 1 || msgs[0].Context != h.Nick || !strings.Contains(msgs[0].Text, "echo arguments") {
+		t.Errorf("help: got %+v", msgs)
+	}
+	cmds := []string{}
+	(&bort.Plugin{}).Commands(struct{}{}, &cmds)
+	if want := []string{"echo", "fail", "jobs", "plugin", "reload"}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands: got %q, want %q", cmds, want)
+	}
+	plugins := []string{}
+	(&bort.Plugin{}).Plugins(struct{}{}, &plugins)
+	if want := []string{"bort_test"}; !reflect.DeepEqual(plugins, want) {
+		t.Errorf("plugins: got %q, want %q", plugins, want)
+	}
+}
+
+func TestPush(t *testing.T) {
+	h := borttest.New(t, "")
+	bort.Push(&bort.Message{Type: bort.PrivMsg, Text: "one"})
+	bort.Push(&bort.Message{Type: bort.PrivMsg, Text: "two"})
+	if got := borttest.Texts(h.Pushes()); !reflect.DeepEqual(got, []string{"one", "two"}) {
+		t.Errorf("got %q", got)
+	}
+	if got := h.Pushes(); len(got) != 0 {
+		t.Errorf("got %d pushes after pull", len(got))
+	}
+}
+
+func TestClock(t *testing.T) {
+	h := borttest.New(t, "")
+	start := bort.Now()
+	ch := bort.After(time.Minute)
+	h.Clock.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("timer fired early")
+	default:
+	}
+	h.Clock.Advance(time.Second)
+	select {
+	case now := <-ch:
+		if now.Sub(start) != time.Minute {
+			t.Errorf("timer fired at %s", now.Sub(start))
+		}
+	default:
+		t.Fatal("timer didn't fire")
+	}
+}
+
+func TestPluginCommand(t *testing.T) {
+	h := borttest.New(t, `{"Admins": ["admin"]}`)
+	bort.RegisterCommand("echo", "echo arguments", echo)
+	h.Nick = "admin"
+
+	tests := []struct {
+		args, want string
+	}{
+		{"list", "plugins in #test: bort_test"},
+		{"disable bort_test", "bort_test disabled in #test"},
+		{"list", "plugins in #test: bort_test (disabled)"},
+		{"list #other", "plugins in #other: bort_test"},
+		{"disable nope", ""},
+		{"frob", "usage: plugin list [channel] | plugin enable|disable <plugin> [channel|*]"},
+	}
+	for _, test := range tests {
+		msgs, err := h.Command("plugin", test.args)
+		if got := borttest.Texts(msgs); test.want == "" && err == nil {
+			t.Errorf("%s: got %q, expected error", test.args, got)
+		} else if test.want != "" && (len(got) != 1 || got[0] != test.want) {
+			t.Errorf("%s: got %q, %v, want %q", test.args, got, err, test.want)
+		}
+	}
+	if msgs, _ := h.Command("echo", "hi"); len(msgs) != 0 {
+		t.Errorf("disabled echo: got %q", borttest.Texts(msgs))
+	}
+
+	// state persists across restarts
+	if err := h.Reload(`{"Admins": ["admin"]}`); err != nil {
+		t.Fatal(err)
+	}
+	bort.PluginInit(borttest.OutboxSize)
+	if msgs, _ := h.Command("echo", "hi"); len(msgs) != 0 {
+		t.Errorf("disabled echo after restart: got %q", borttest.Texts(msgs))
+	}
+	h.Command("plugin", "enable bort_test *")
+	if msgs, _ := h.Command("echo", "hi"); len(msgs) != 1 {
+		t.Errorf("enabled echo: got %q", borttest.Texts(msgs))
+	}
+}
+
+func TestJobs(t *testing.T) {
+	h := borttest.New(t, `{"Admins": ["admin"]}`)
+	counts := map[string]int{}
+	counter := func(name string) bort.JobFunc {
+		return func(out *bort.Message) error {
+			counts[name]++
+			out.Type = bort.PrivMsg
+			out.Text = name
+			return nil
+		}
+	}
+	hourly, err := bort.Cron("@hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bort.Cron("61 * * * *"); err == nil {
+		t.Error("expected error for invalid cron expression")
+	}
+	bort.RegisterJob("minutely", bort.Every(time.Minute), counter("minutely"))
+	bort.RegisterJob("hourly", hourly, counter("hourly"))
+	bort.RegisterJob("once", bort.At(bort.Now().Add(90*time.Second)), counter("once"))
+	if err := bort.RegisterJob("once", bort.Every(time.Second), counter("once")); err == nil {
+		t.Error("expected error registering duplicate job")
+	}
+
+	h.Nick = "admin"
+	msgs, _ := h.Command("jobs", "")
+	want := "hourly: cron @hourly, next 2000-01-01 01:00:00\n" +
+		"minutely: every 1m0s, next 2000-01-01 00:01:00\n" +
+		"once: at 2000-01-01T00:01:30Z, next 2000-01-01 00:01:30"
+	if got := borttest.Texts(msgs); len(got) != 1 || got[0] != want {
+		t.Errorf("jobs: got %q, want %q", got, want)
+	}
+
+	msgs = h.Advance(time.Hour)
+	if len(msgs) != 62 || counts["minutely"] != 60 || counts["hourly"] != 1 || counts["once"] != 1 {
+		t.Errorf("got %d pushes, counts %v", len(msgs), counts)
+	}
+
+	// jobs don't run while detached, except delayed final runs
+	bort.RegisterJob("later", bort.At(bort.Now().Add(time.Minute)), counter("later"))
+	h.Clock.Advance(10 * time.Minute)
+	bort.RunJobs()
+	if counts["minutely"] != 60 || counts["later"] != 0 {
+		t.Errorf("detached: counts %v", counts)
+	}
+	h.Pushes()
+	bort.RunJobs()
+	if counts["minutely"] != 60 || counts["later"] != 1 {
+		t.Errorf("reattached: counts %v", counts)
+	}
+	if !bort.UnregisterJob("minutely") || bort.UnregisterJob("once") {
+		t.Error("unregistering: expected only minutely to be registered")
+	}
+}
+
+func TestJobPanic(t *testing.T) {
+	h := borttest.New(t, "")
+	panics, runs := 0, 0
+	bort.RegisterJob("boom", bort.Every(time.Minute), func(out *bort.Message) error {
+		panics++
+		panic("boom")
+	})
+	bort.RegisterJob("fine", bort.Every(time.Minute), func(out *bort.Message) error {
+		runs++
+		out.Type = bort.PrivMsg
+		out.Text = "fine"
+		return nil
+	})
+
+	msgs := h.Advance(5 * time.Minute)
+	if panics != 3 || runs != 5 || len(msgs) != 5 {
+		t.Errorf("got %d panics, %d runs, %d pushes", panics, runs, len(msgs))
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	h := borttest.New(t, `{"HandlerTimeout": 60, "HandlerTimeouts": {"wait": 1}}`)
+	canceled := make(chan error, 1)
+	bort.RegisterCommandContext("wait", "wait to be canceled", func(ctx context.Context, in, out *bort.Message) error {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		out.Type = bort.PrivMsg
+		return nil
+	})
+	msgs, err := h.Command("wait", "")
+	if err == nil || err.Error() != "wait: timed out after 1s" || len(msgs) != 0 {
+		t.Errorf("got %q, %v", borttest.Texts(msgs), err)
+	}
+	if err := <-canceled; err != context.DeadlineExceeded {
+		t.Errorf("handler context: got %v", err)
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	h := borttest.New(t, "")
+	boom := func(in, out *bort.Message) error {
+		var s []string
+		out.Text = s[1]
+		return nil
+	}
+	bort.RegisterCommand("boom", "always panic", boom)
+	bort.RegisterCommand("rarely", "panic now and then", boom)
+
+	for i := 0; i < 3; i++ {
+		msgs, err := h.Command("boom", "")
+		if err != nil || len(msgs) != 1 || msgs[0].Text != "Sorry, something went wrong." {
+			t.Errorf("panic %d: got %q, %v", i+1, borttest.Texts(msgs), err)
+		}
+	}
+	if msgs, _ := h.Command("boom", ""); len(msgs) != 0 {
+		t.Errorf("disabled: got %q", borttest.Texts(msgs))
+	}
+	if msgs, _ := h.Command("help", ""); strings.Contains(msgs[0].Text, "boom") {
+		t.Error("help lists disabled command")
+	}
+
+	// only panics within the window count
+	h.Command("rarely", "")
+	h.Clock.Advance(time.Hour)
+	h.Command("rarely", "")
+	h.Command("rarely", "")
+	if msgs, _ := h.Command("rarely", ""); len(msgs) != 1 {
+		t.Errorf("disabled too soon: got %q", borttest.Texts(msgs))
+	}
+
+	// matcher panics are only logged
+	bort.RegisterMatcher(bort.PrivMsg, "kaboom", boom)
+	if msgs, err := h.Send(&bort.Message{Type: bort.PrivMsg, Text: "kaboom"}); err != nil || len(msgs) != 0 {
+		t.Errorf("matcher panic: got %q, %v", borttest.Texts(msgs), err)
+	}
+}
+
+func TestHandlerLatePanic(t *testing.T) {
+	h := borttest.New(t, `{"HandlerTimeouts": {"late": 1}}`)
+	bort.RegisterCommandContext("late", "panic after timing out", func(ctx context.Context, in, out *bort.Message) error {
+		<-ctx.Done()
+		panic("late")
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := h.Command("late", ""); err == nil {
+			t.Errorf("%d: expected timeout", i+1)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if msgs, _ := h.Command("help", ""); !strings.Contains(msgs[0].Text, "late") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("not disabled after panicking late")
+}
+
+func TestFormatting(t *testing.T) {
+	h := borttest.New(t, `{"ChannelConfig": {"#plain": {"NoColor": true}}}`)
+	raw := ""
+	bort.RegisterCommand("color", "reply in color", func(in, out *bort.Message) error {
+		raw = in.Raw
+		out.Type = bort.PrivMsg
+		out.Text = ircfmt.Bold(in.Args) + " " + ircfmt.Colored(ircfmt.Red, in.Text)
+		return nil
+	})
+
+	in := &bort.Message{Type: bort.PrivMsg, Command: "color", Args: "\x02a\x02", Text: "\x0304color\x03 a"}
+	msgs, _ := h.Send(in)
+	if got, want := borttest.Texts(msgs), []string{"\x02a\x02 \x0304color a\x03"}; !reflect.DeepEqual(got, want) || raw != "\x0304color\x03 a" {
+		t.Errorf("got %q, raw %q, want %q", got, raw, want)
+	}
+	h.Channel = "#plain"
+	msgs, _ = h.Send(&bort.Message{Type: bort.PrivMsg, Command: "color", Args: "a", Text: "color a"})
+	if got, want := borttest.Texts(msgs), []string{"\x02a\x02 color a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("no color: got %q, want %q", got, want)
+	}
+}
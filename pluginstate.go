@@ -0,0 +1,123 @@
+package bort
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// allChannels is the pluginState key for state applying to all channels.
+	allChannels = "*"
+
+	defaultStateFile = "plugins.json"
+)
+
+// pluginState records plugins enabled or disabled with the plugin admin
+// command, by lower case channel (or allChannels) and plugin name.
+var pluginState = map[string]map[string]bool{}
+
+// stateMut serializes changes to pluginState through writing the file, so
+// concurrent changes can't interleave writes or persist out of order.
+var stateMut sync.Mutex
+
+// pluginStateFile returns the path of the file persisting pluginState, given
+// by the PluginState configuration value, relative to the configuration file.
+func pluginStateFile() string {
+	core := coreConfig{}
+	GetConfig(&core)
+	path := core.PluginState
+	if path == "" {
+		path = defaultStateFile
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	cfgMut.RLock()
+	defer cfgMut.RUnlock()
+
+	dir := filepath.Dir(defaultCfgFile)
+	if configFile != "" {
+		dir = filepath.Dir(configFile)
+	}
+	return filepath.Join(dir, path)
+}
+
+// loadPluginState loads the persisted plugin state, if any.
+func loadPluginState() error {
+	state := map[string]map[string]bool{}
+	data, err := ioutil.ReadFile(pluginStateFile())
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	cfgMut.Lock()
+	defer cfgMut.Unlock()
+
+	pluginState = state
+	return nil
+}
+
+// setPluginState enables or disables a plugin in a channel, or in all channels
+// if channel is allChannels, and persists the state.  Enabling or disabling in
+// all channels clears the state of individual channels.
+func setPluginState(plugin, channel string, enabled bool) error {
+	plugin, channel = strings.ToLower(plugin), strings.ToLower(channel)
+	path := pluginStateFile()
+
+	stateMut.Lock()
+	defer stateMut.Unlock()
+
+	cfgMut.Lock()
+	if channel == allChannels {
+		for _, plugins := range pluginState {
+			delete(plugins, plugin)
+		}
+	}
+	if pluginState[channel] == nil {
+		pluginState[channel] = map[string]bool{}
+	}
+	pluginState[channel][plugin] = enabled
+	data, err := json.MarshalIndent(pluginState, "", "\t")
+	cfgMut.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// pluginNames lists the plugins that have registered handlers.
+func pluginNames() []string {
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	seen := map[string]bool{}
+	for _, cmd := range commands {
+		seen[cmd.plugin] = true
+	}
+	for _, m := range matchers {
+		seen[m.plugin] = true
+	}
+	delete(seen, "")
+	names := []string{}
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,296 @@
+package bort
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// attachTimeout is how long after its last pull bort is considered attached.
+const attachTimeout = time.Minute
+
+var (
+	jobs      = map[string]*job{}
+	lastPull  time.Time
+	schedWake = make(chan struct{}, 1)
+	schedQuit chan struct{} // closed to stop the scheduler
+	schedMut  sync.Mutex    // guards jobs, lastPull, schedQuit, and delayed pushes
+	runMut    sync.Mutex    // held while jobs run
+)
+
+// JobFunc is a scheduled job.  If it sets out's Type, out is pushed.  An empty
+// Context is bort's default channel.
+type JobFunc func(out *Message) error
+
+// Schedule determines when a job runs.  Next returns the first run time after
+// the given time, or the zero time if there are no more runs.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+type every time.Duration
+
+func (e every) Next(after time.Time) time.Time { return after.Add(time.Duration(e)) }
+func (e every) String() string                 { return "every " + time.Duration(e).String() }
+
+// Every returns a schedule that runs every d.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type at time.Time
+
+func (a at) Next(after time.Time) time.Time {
+	if t := time.Time(a); t.After(after) {
+		return t
+	}
+	return time.Time{}
+}
+
+func (a at) String() string { return "at " + time.Time(a).Format(time.RFC3339) }
+
+// At returns a schedule that runs once at t.
+func At(t time.Time) Schedule {
+	return at(t)
+}
+
+type cronSchedule struct {
+	cron.Schedule
+	spec string
+}
+
+func (c cronSchedule) String() string { return "cron " + c.spec }
+
+// Cron returns a schedule for a standard cron expression, such as
+// "30 8 * * mon-fri", or a descriptor such as "@hourly" or "@every 90m".
+// Times are in the local time zone.
+func Cron(spec string) (Schedule, error) {
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	return cronSchedule{Schedule: sched, spec: spec}, nil
+}
+
+type job struct {
+	name    string
+	sched   Schedule
+	handler *handler
+	plugin  string
+	next    time.Time
+}
+
+// RegisterJob registers a job to be run on a schedule while bort is attached,
+// that is, has pulled pushed messages within the last minute.  Runs that fall
+// while bort isn't attached are skipped, except the last run of a schedule
+// (such as that of At), which is delayed until bort attaches.  The job belongs
+// to the calling package's plugin, and its messages are refused where the
+// plugin is disabled.  Jobs run like handlers: one that doesn't return by the
+// plugin's handler deadline is abandoned, and one that panics too often is
+// disabled.  Administrators can list jobs with the jobs command.
+func RegisterJob(name string, sched Schedule, run JobFunc) error {
+	plugin := callerPlugin()
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	if name == "" {
+		return fmt.Errorf("cannot register empty job name")
+	}
+	if _, ok := jobs[name]; ok {
+		return fmt.Errorf("%s: job already registered", name)
+	}
+	handle := func(ctx context.Context, in, out *Message) error { return run(out) }
+	j := &job{name: name, sched: sched, plugin: plugin,
+		handler: &handler{handle: handle, plugin: plugin, job: name}}
+	if schedQuit != nil {
+		j.next = sched.Next(Now())
+	}
+	jobs[name] = j
+	wakeScheduler()
+	return nil
+}
+
+// UnregisterJob unregisters the named job, if found, and returns whether a job
+// was removed.
+func UnregisterJob(name string) bool {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	_, ok := jobs[name]
+	delete(jobs, name)
+	return ok
+}
+
+// RunJobs runs the jobs and delivers the delayed pushes that are due.  The
+// scheduler calls it as jobs become due, and tests may call it after advancing
+// the clock to run them promptly.
+func RunJobs() {
+	runJobs()
+}
+
+// runJobs runs due jobs and delivers due delayed pushes, and returns the time
+// until the next is due, or 0 if none are.
+func runJobs() time.Duration {
+	runMut.Lock()
+	defer runMut.Unlock()
+
+	schedMut.Lock()
+	now := Now()
+	due, wait := dueJobs(now)
+	pushes, pushWait := duePushes(now)
+	schedMut.Unlock()
+	for _, j := range due {
+		j.runOnce()
+	}
+	for _, dp := range pushes {
+		dp.deliver()
+	}
+	if pushWait > 0 && (wait == 0 || pushWait < wait) {
+		wait = pushWait
+	}
+	return wait
+}
+
+// dueJobs returns the jobs to run now, and the time until the next is due, or
+// 0 if none are.  It schedules the jobs' next runs.
+func dueJobs(now time.Time) ([]*job, time.Duration) {
+	due := []*job{}
+	var wait time.Duration
+	for name, j := range jobs {
+		if j.next.IsZero() {
+			j.next = j.sched.Next(now)
+		}
+		if !j.next.IsZero() && !j.next.After(now) {
+			next := j.sched.Next(now)
+			switch {
+			case attached(now):
+				due = append(due, j)
+				j.next = next
+			case next.IsZero():
+				continue // last run, delayed until bort attaches
+			default:
+				j.next = next // skipped
+			}
+		}
+		if j.next.IsZero() {
+			delete(jobs, name)
+			continue
+		}
+		if d := j.next.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return due, wait
+}
+
+// Attached reports whether bort is attached, having pulled pushed messages
+// recently.
+func Attached() bool {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	return attached(Now())
+}
+
+// attached reports whether bort has pulled recently enough to be attached.
+func attached(now time.Time) bool {
+	return !lastPull.IsZero() && now.Sub(lastPull) < attachTimeout
+}
+
+// runOnce runs the job, unless disabled, and pushes its message.
+func (j *job) runOnce() {
+	if j.handler.isDisabled() {
+		return
+	}
+	out, _, err := j.handler.run(&Message{})
+	if err != nil {
+		pluginLogger(j.plugin).Error("job failed", "job", j.name, "err", err)
+		return
+	}
+	if out.Type == None {
+		return
+	}
+	if err := push(j.plugin, &out); err != nil {
+		pluginLogger(j.plugin).Error("pushing job message", "job", j.name, "err", err)
+	}
+}
+
+// attach records that bort pulled pushed messages, so jobs may run.
+func attach() {
+	schedMut.Lock()
+	now := Now()
+	wasAttached := attached(now)
+	lastPull = now
+	schedMut.Unlock()
+
+	if !wasAttached {
+		wakeScheduler() // run delayed jobs
+	}
+}
+
+// wakeScheduler has the scheduler recheck the jobs.
+func wakeScheduler() {
+	select {
+	case schedWake <- struct{}{}:
+	default:
+	}
+}
+
+// startScheduler starts running jobs, if not already started.
+func startScheduler() {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	if schedQuit != nil {
+		return
+	}
+	now := Now()
+	for _, j := range jobs {
+		j.next = j.sched.Next(now)
+	}
+	schedQuit = make(chan struct{})
+	go runScheduler(schedQuit)
+}
+
+// runScheduler runs jobs as they become due, until quit is closed.
+func runScheduler(quit chan struct{}) {
+	for {
+		wait := runJobs()
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = After(wait)
+		}
+		select {
+		case <-timer:
+		case <-schedWake:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// jobList describes the scheduled jobs.
+func jobList() []string {
+	schedMut.Lock()
+	defer schedMut.Unlock()
+
+	list := []string{}
+	for name, j := range jobs {
+		desc := fmt.Sprint(j.sched)
+		if _, ok := j.sched.(fmt.Stringer); !ok {
+			desc = "custom schedule"
+		}
+		next := "pending"
+		if !j.next.IsZero() {
+			next = j.next.Format("2006-01-02 15:04:05")
+		}
+		list = append(list, fmt.Sprintf("%s: %s, next %s", name, desc, next))
+	}
+	sort.Strings(list)
+	return list
+}
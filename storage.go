@@ -0,0 +1,309 @@
+package bort
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultStorage = "bort.db"
+	memoryStorage  = ":memory:"
+)
+
+var (
+	// ErrNotFound is returned for keys missing from storage.
+	ErrNotFound = errors.New("not found")
+
+	store    Store
+	storeMut sync.RWMutex // guards store
+)
+
+// Store is a key-value store, with keys grouped in buckets.  Plugins use it
+// through Bucket, which confines each to its own bucket.
+type Store interface {
+	// Get returns the value of key in bucket, or ErrNotFound.
+	Get(bucket, key string) ([]byte, error)
+	// Put sets the value of key in bucket.  A nil value deletes key.
+	Put(bucket, key string, value []byte) error
+	// Delete removes key from bucket, if present.
+	Delete(bucket, key string) error
+	// ForEach calls fn for each key in bucket, in order, until fn returns an
+	// error.  fn must not modify the store.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	// Update atomically replaces the value of key in bucket with that
+	// returned by fn, which is passed the current value, or nil if there is
+	// none.  If fn returns a nil value, key is deleted, and if it returns an
+	// error, nothing changes.
+	Update(bucket, key string, fn func(value []byte) ([]byte, error)) error
+	// Close releases the store's resources.
+	Close() error
+}
+
+// SetStore sets the store used by plugins, closing the previous one, if any.
+// It is intended for tests; bortplug opens the store given by the Storage
+// configuration value.
+func SetStore(s Store) {
+	storeMut.Lock()
+	defer storeMut.Unlock()
+
+	if store != nil {
+		store.Close()
+	}
+	store = s
+}
+
+// openStore opens the store given by the Storage configuration value, if one
+// isn't open.  Storage is a file path, relative to the configuration file, or
+// ":memory:" for a store that isn't persisted.
+func openStore() error {
+	storeMut.Lock()
+	defer storeMut.Unlock()
+
+	if store != nil {
+		return nil
+	}
+	core := coreConfig{}
+	GetConfig(&core)
+	path := core.Storage
+	if path == "" {
+		path = defaultStorage
+	}
+	if path == memoryStorage {
+		store = NewMemoryStore()
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		cfgMut.RLock()
+		path = filepath.Join(filepath.Dir(configFile), path)
+		cfgMut.RUnlock()
+	}
+	s, err := NewBoltStore(path)
+	if err != nil {
+		return err
+	}
+	store = s
+	return nil
+}
+
+// getStore returns the store, opening a memory store if none is open.
+func getStore() Store {
+	storeMut.RLock()
+	s := store
+	storeMut.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	storeMut.Lock()
+	defer storeMut.Unlock()
+
+	if store == nil {
+		slog.Warn("storage not opened, using memory")
+		store = NewMemoryStore()
+	}
+	return store
+}
+
+// Bucket is a plugin's namespace in storage.
+type Bucket struct {
+	name string
+}
+
+// PluginBucket returns the calling package's plugin's bucket.  Bortplug keeps
+// the store in bort.db next to the configuration file, or the file given by the
+// Storage configuration value (":memory:" for no persistence), and won't start
+// if it can't be opened, such as when another bortplug has it open.  The
+// borttest harness gives each test an empty memory store.
+func PluginBucket() *Bucket {
+	name := callerPlugin()
+	if name == "" {
+		name = "bort"
+	}
+	return &Bucket{name: name}
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (b *Bucket) Get(key string) ([]byte, error) {
+	return getStore().Get(b.name, key)
+}
+
+// Put sets the value of key.  A nil value deletes key.
+func (b *Bucket) Put(key string, value []byte) error {
+	return getStore().Put(b.name, key, value)
+}
+
+// Delete removes key, if present.
+func (b *Bucket) Delete(key string) error {
+	return getStore().Delete(b.name, key)
+}
+
+// ForEach calls fn for each key, in order, until fn returns an error.  fn must
+// not modify the bucket.
+func (b *Bucket) ForEach(fn func(key string, value []byte) error) error {
+	return getStore().ForEach(b.name, fn)
+}
+
+// Update atomically replaces the value of key with that returned by fn (see
+// Store).
+func (b *Bucket) Update(key string, fn func(value []byte) ([]byte, error)) error {
+	return getStore().Update(b.name, key, fn)
+}
+
+// boltStore is a Store in a bbolt database file.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates a Store in the bbolt database file at path.
+func NewBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) Put(bucket, key string, value []byte) error {
+	return s.Update(bucket, key, func([]byte) ([]byte, error) { return value, nil })
+}
+
+func (s *boltStore) Delete(bucket, key string) error {
+	return s.Update(bucket, key, func([]byte) ([]byte, error) { return nil, nil })
+}
+
+func (s *boltStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), append([]byte(nil), v...))
+		})
+	})
+}
+
+func (s *boltStore) Update(bucket, key string, fn func(value []byte) ([]byte, error)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		var old []byte
+		if v := b.Get([]byte(key)); v != nil {
+			old = append([]byte(nil), v...)
+		}
+		value, err := fn(old)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return b.Delete([]byte(key))
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// memoryStore is a Store in memory, for tests or when persistence isn't
+// wanted.
+type memoryStore struct {
+	mut     sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore creates an empty Store in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: map[string]map[string][]byte{}}
+}
+
+func (s *memoryStore) Get(bucket, key string) ([]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	v, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (s *memoryStore) Put(bucket, key string, value []byte) error {
+	return s.Update(bucket, key, func([]byte) ([]byte, error) { return value, nil })
+}
+
+func (s *memoryStore) Delete(bucket, key string) error {
+	return s.Update(bucket, key, func([]byte) ([]byte, error) { return nil, nil })
+}
+
+func (s *memoryStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	s.mut.Lock()
+	keys := []string{}
+	values := map[string][]byte{}
+	for k, v := range s.buckets[bucket] {
+		keys = append(keys, k)
+		values[k] = append([]byte(nil), v...)
+	}
+	s.mut.Unlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Update(bucket, key string, fn func(value []byte) ([]byte, error)) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var old []byte
+	if v, ok := s.buckets[bucket][key]; ok {
+		old = append([]byte(nil), v...)
+	}
+	value, err := fn(old)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		delete(s.buckets[bucket], key)
+		return nil
+	}
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = map[string][]byte{}
+	}
+	s.buckets[bucket][key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package bort_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+func testStore(t *testing.T, s bort.Store) {
+	if _, err := s.Get("a", "x"); err != bort.ErrNotFound {
+		t.Errorf("get missing: got %v", err)
+	}
+	s.Put("a", "x", []byte("1"))
+	s.Put("a", "y", []byte("2"))
+	s.Put("b", "x", []byte("3"))
+	if v, err := s.Get("a", "x"); err != nil || string(v) != "1" {
+		t.Errorf("get: got %q, %v", v, err)
+	}
+
+	incr := func(v []byte) ([]byte, error) {
+		n, _ := strconv.Atoi(string(v))
+		return []byte(strconv.Itoa(n + 1)), nil
+	}
+	s.Update("a", "x", incr)
+	s.Update("a", "z", incr)
+	failed := errors.New("failed")
+	if err := s.Update("a", "y", func([]byte) ([]byte, error) { return nil, failed }); err != failed {
+		t.Errorf("failed update: got %v", err)
+	}
+	s.Delete("b", "x")
+
+	got := map[string]string{}
+	s.ForEach("a", func(k string, v []byte) error {
+		got[k] = string(v)
+		return nil
+	})
+	if want := map[string]string{"x": "2", "y": "2", "z": "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("for each: got %v, want %v", got, want)
+	}
+	if _, err := s.Get("b", "x"); err != bort.ErrNotFound {
+		t.Errorf("get deleted: got %v", err)
+	}
+	s.Put("b", "y", []byte("4"))
+	s.Put("b", "y", nil)
+	if _, err := s.Get("b", "y"); err != bort.ErrNotFound {
+		t.Errorf("get after putting nil: got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, bort.NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bort.db")
+	s, err := bort.NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	s.Close()
+
+	if s, err = bort.NewBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, err := s.Get("a", "z"); err != nil || string(v) != "1" {
+		t.Errorf("after reopening: got %q, %v", v, err)
+	}
+}
+
+func TestStorageError(t *testing.T) {
+	defer bort.Isolate()()
+	cfgFile := filepath.Join(t.TempDir(), "bort.conf")
+	if err := os.WriteFile(cfgFile, []byte(`{"Storage": "missing/bort.db"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bort.LoadConfig(&struct{}{}, cfgFile); err != nil {
+		t.Fatal(err)
+	}
+	bort.SetStore(nil)
+	if err := bort.PluginInit(1); err == nil {
+		t.Error("expected error opening storage")
+	}
+}
+
+func TestPluginBucket(t *testing.T) {
+	borttest.New(t, "")
+	store := bort.NewMemoryStore()
+	bort.SetStore(store)
+	bort.PluginBucket().Put("key", []byte("value"))
+	if v, err := store.Get("bort_test", "key"); err != nil || string(v) != "value" {
+		t.Errorf("got %q, %v", v, err)
+	}
+}
@@ -0,0 +1,161 @@
+package bort
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// Command is a command with subcommands, such as "quote add" and "quote list".
+// Subcommands are added with Sub and SubArgs, which may be chained:
+//
+//	bort.RegisterCommandGroup("quote", "remember quotes", nil).
+//		Sub("list", "list quotes", list).
+//		SubArgs("add", "add a quote", addArgs, add)
+//
+// The subcommand is the first word of the arguments, which are passed on
+// without it.  "quote help" lists the subcommands.
+type Command struct {
+	name   string
+	handle ContextHandleFunc
+	subs   map[string]*subcommand // guarded by regMut
+	err    error
+}
+
+type subcommand struct {
+	help   string
+	usage  string
+	handle ContextHandleFunc
+}
+
+// RegisterCommandGroup registers a command, like RegisterCommand, and returns
+// it so subcommands can be added, such as "quote add" and "quote list".  The
+// subcommand is routed to its handler with the remaining arguments, and the
+// help subcommand, as in "quote help", lists the subcommands.  handle, if not
+// nil, handles the command given without a known subcommand; otherwise, the
+// usage is replied.
+func RegisterCommandGroup(cmd, help string, handle HandleFunc) *Command {
+	c := &Command{name: cmd, subs: map[string]*subcommand{}}
+	if handle != nil {
+		c.handle = handle.withContext()
+	}
+	c.err = registerCommand(callerPlugin(), cmd, help, c.dispatch)
+	return c
+}
+
+// Sub adds a subcommand with a handler, and returns the command.
+func (c *Command) Sub(name, help string, handle HandleFunc) *Command {
+	return c.addSub(name, &subcommand{help: help, handle: handle.withContext()})
+}
+
+// SubArgs adds a subcommand with a handler that is passed parsed arguments,
+// like RegisterCommandArgs, and returns the command.
+func (c *Command) SubArgs(name, help string, spec []Arg, handle ArgsHandleFunc) *Command {
+	cmd := c.name + " " + name
+	return c.addSub(name, &subcommand{help: help, usage: Usage(cmd, spec), handle: withArgs(cmd, spec, handle)})
+}
+
+// Err returns the first error registering the command or its subcommands, if
+// any.
+func (c *Command) Err() error {
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	return c.err
+}
+
+func (c *Command) addSub(name string, sub *subcommand) *Command {
+	regMut.Lock()
+	defer regMut.Unlock()
+
+	var err error
+	switch _, ok := c.subs[name]; {
+	case name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0:
+		err = fmt.Errorf("%s: invalid subcommand name '%s'", c.name, name)
+	case name == "help":
+		err = fmt.Errorf("%s: help subcommand is reserved", c.name)
+	case ok:
+		err = fmt.Errorf("%s %s: subcommand already registered", c.name, name)
+	default:
+		c.subs[name] = sub
+	}
+	if c.err == nil {
+		c.err = err
+	}
+	return c
+}
+
+// dispatch passes a message to the handler of its subcommand.
+func (c *Command) dispatch(ctx context.Context, in, out *Message) error {
+	name, rest := splitWord(in.Args)
+	regMut.RLock()
+	sub, ok := c.subs[name]
+	regMut.RUnlock()
+	switch {
+	case ok:
+		in.Args = rest
+		return sub.handle(ctx, in, out)
+	case name == "help":
+		out.Type = PrivMsg
+		out.Context = in.Nick
+		out.Text = c.helpText()
+	case c.handle != nil:
+		return c.handle(ctx, in, out)
+	default:
+		out.Type = PrivMsg
+		out.Text = c.usage()
+	}
+	return nil
+}
+
+// names returns the sorted subcommand names.
+func (c *Command) names() []string {
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	names := []string{}
+	for name := range c.subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usage returns a usage message listing the subcommands.
+func (c *Command) usage() string {
+	names := append(c.names(), "help")
+	return fmt.Sprintf("usage: %s %s ...", c.name, strings.Join(names, "|"))
+}
+
+// helpText lists the subcommands with their help and usage.
+func (c *Command) helpText() string {
+	names := c.names()
+	regMut.RLock()
+	defer regMut.RUnlock()
+
+	buf := &bytes.Buffer{}
+	tabWrite := tabwriter.NewWriter(buf, 2, 0, 1, ' ', 0)
+	for _, name := range names {
+		sub := c.subs[name]
+		fmt.Fprintf(tabWrite, "%s %s:\t%s\n", c.name, name, sub.help)
+		if sub.usage != "" {
+			fmt.Fprintf(tabWrite, "\t%s\n", sub.usage)
+		}
+	}
+	tabWrite.Flush()
+	return buf.String()
+}
+
+// splitWord splits the first word from text, returning it and the rest.
+func splitWord(text string) (string, string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimLeftFunc(text[i:], unicode.IsSpace)
+}
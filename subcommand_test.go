@@ -0,0 +1,54 @@
+package bort_test
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ianremmler/bort"
+	"github.com/ianremmler/bort/borttest"
+)
+
+func TestSubcommands(t *testing.T) {
+	h := borttest.New(t, "")
+	quotes := []string{}
+	addArgs := []bort.Arg{{Name: "quote", Type: bort.RestArg}}
+	cmd := bort.RegisterCommandGroup("quote", "remember quotes", nil).
+		Sub("count", "count quotes", func(in, out *bort.Message) error {
+			out.Type = bort.PrivMsg
+			out.Text = strconv.Itoa(len(quotes))
+			return nil
+		}).
+		SubArgs("add", "add a quote", addArgs, func(ctx context.Context, in, out *bort.Message, args *bort.Args) error {
+			quotes = append(quotes, args.String("quote"))
+			return nil
+		})
+	if err := cmd.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Sub("add", "", echo).Err() == nil {
+		t.Error("expected error adding duplicate subcommand")
+	}
+
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{"add  to be or not", []string{}},
+		{"count", []string{"1"}},
+		{"add", []string{"quote add: missing quote\nusage: quote add <quote...>"}},
+		{"", []string{"usage: quote add|count|help ..."}},
+		{"frob", []string{"usage: quote add|count|help ..."}},
+		{"help", []string{"quote add:   add a quote\n             usage: quote add <quote...>\nquote count: count quotes\n"}},
+	}
+	for _, test := range tests {
+		msgs, err := h.Command("quote", test.args)
+		if got := borttest.Texts(msgs); err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, %v, want %q", test.args, got, err, test.want)
+		}
+	}
+	if want := []string{"to be or not"}; !reflect.DeepEqual(quotes, want) {
+		t.Errorf("got quotes %q, want %q", quotes, want)
+	}
+}
@@ -0,0 +1,530 @@
+package bort
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var sections = []*section{} // guarded by cfgMut
+
+// Validator is implemented by configuration values that check constraints
+// beyond their types.  An error may wrap multiple errors (as errors.Join
+// does), and FieldErrors locate problems within the value.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError reports a problem with the value at a path of keys, relative to
+// the configuration value being validated.
+type FieldError struct {
+	Path []string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return strings.Join(e.Path, ".") + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigProblem describes a problem found in the configuration.  Fatal
+// problems prevent the configuration from being loaded.
+type ConfigProblem struct {
+	File  string
+	Line  int    // 0 if unknown
+	Key   string // dot separated path of keys, if known
+	Msg   string
+	Fatal bool
+}
+
+func (p ConfigProblem) String() string {
+	str := p.File
+	if p.Line > 0 {
+		str += fmt.Sprintf(":%d", p.Line)
+	}
+	str += ": "
+	if p.Key != "" {
+		str += p.Key + ": "
+	}
+	str += p.Msg
+	if !p.Fatal {
+		str += " (warning)"
+	}
+	return str
+}
+
+// ConfigProblems is an error listing fatal configuration problems.
+type ConfigProblems []ConfigProblem
+
+func (ps ConfigProblems) Error() string {
+	strs := []string{}
+	for _, p := range ps {
+		strs = append(strs, p.String())
+	}
+	return strings.Join(strs, "\n")
+}
+
+// section is a declared part of the configuration.
+type section struct {
+	key      string
+	defaults reflect.Value
+}
+
+// RegisterConfig declares a configuration section, so it can be checked for
+// unknown keys, type errors, and constraint violations.  key is the section's
+// key at the top level of the configuration, or "" if its keys are at the top
+// level.  cfg is a pointer to a struct whose fields declare the section's keys
+// and types, and whose values are the defaults.  If cfg implements Validator,
+// it is called to check constraints.  Plugins should call RegisterConfig from
+// init(), so the configuration can be checked before it is loaded.
+func RegisterConfig(key string, cfg interface{}) {
+	val := reflect.ValueOf(cfg)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		panic("bort: RegisterConfig requires a pointer to a struct")
+	}
+	defaults := reflect.New(val.Elem().Type()).Elem()
+	defaults.Set(val.Elem())
+
+	cfgMut.Lock()
+	defer cfgMut.Unlock()
+
+	sections = append(sections, &section{key: key, defaults: defaults})
+}
+
+// CheckConfig checks the given or default configuration file, with overrides
+// applied, against the registered configuration sections.  Keys at the top
+// level that no section declares are reported as warnings, as they may be
+// used by the other command; other problems are fatal, so a command refuses to
+// start and a reload is rejected.  Both commands' -check-config flag lists the
+// problems.  Problems are located by line in JSON and YAML files, but not in
+// TOML files, or when caused by environment variables.
+func CheckConfig(cfgFile string) ([]ConfigProblem, error) {
+	if cfgFile == "" {
+		cfgFile = defaultCfgFile
+	}
+	data, err := ioutil.ReadFile(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	return checkConfig(cfgFile, data, os.Environ()), nil
+}
+
+// checkConfig checks configuration data, as read from cfgFile, against the
+// registered configuration sections.
+func checkConfig(cfgFile string, data []byte, env []string) []ConfigProblem {
+	chk := &checker{file: cfgFile, data: data}
+	if format := formatOf(cfgFile); format != jsonFormat {
+		// other formats are checked as the equivalent JSON, with the lines of
+		// YAML keys mapped from it, and TOML without positions
+		if format == yamlFormat {
+			chk.srcLines = yamlLines(data)
+		}
+		var err error
+		if data, err = toJSON(cfgFile, data); err != nil {
+			chk.add(-1, "", err.Error(), true)
+			return chk.problems
+		}
+		chk.data, chk.noPos = data, format != yamlFormat
+	}
+
+	root, err := parseNode(json.NewDecoder(bytes.NewReader(data)))
+	if err != nil {
+		chk.addError(err, "")
+		return chk.problems
+	}
+	chk.root = root
+	cfgMut.RLock()
+	secs := append([]*section(nil), sections...)
+	cfgMut.RUnlock()
+
+	chk.checkKeys(root, secs, "")
+	masked := maskSecrets(data, root)
+	bad := map[*section]bool{}
+	for _, sec := range secs {
+		if chk.checkSection(sec, masked, root) {
+			bad[sec] = true
+		}
+	}
+
+	// check again with overrides applied, so bad values from the environment
+	// are caught, but without positions
+	overridden, ignored, err := applyOverrides(data, filepath.Dir(cfgFile), env)
+	if err != nil {
+		chk.addError(err, "")
+		return chk.problems
+	}
+	chk.noPos = true
+	for _, name := range ignored {
+		chk.add(-1, name, "environment variable ignored, as it names an undeclared key", false)
+	}
+	for _, sec := range secs {
+		if !bad[sec] && chk.checkSection(sec, overridden, nil) {
+			bad[sec] = true
+		}
+	}
+
+	// check each channel's configuration, for sections without problems
+	// already reported
+	for _, ch := range channelsIn(overridden) {
+		chData, err := channelData(overridden, ch)
+		if err != nil {
+			continue
+		}
+		chk.prefix = channelConfigKey + "." + ch + "."
+		for _, sec := range secs {
+			if !bad[sec] {
+				chk.checkSection(sec, chData, nil)
+			}
+		}
+	}
+	sort.SliceStable(chk.problems, func(i, j int) bool {
+		return chk.problems[i].Line < chk.problems[j].Line
+	})
+	return chk.problems
+}
+
+// checker accumulates problems found in configuration data.
+type checker struct {
+	file     string
+	data     []byte
+	noPos    bool
+	root     *jsonNode
+	srcLines map[string]int // if converted from YAML, the lines of keys, by path
+	prefix   string         // for keys of problems in channel configurations
+	problems []ConfigProblem
+}
+
+func (c *checker) add(offset int64, key, msg string, fatal bool) {
+	line := 0
+	if !c.noPos && offset >= 0 {
+		if offset > int64(len(c.data)) {
+			offset = int64(len(c.data))
+		}
+		if c.srcLines != nil {
+			line = c.srcLine(offset)
+		} else {
+			line = 1 + bytes.Count(c.data[:offset], []byte("\n"))
+		}
+	}
+	if key != "" {
+		key = c.prefix + key
+	}
+	c.problems = append(c.problems, ConfigProblem{File: c.file, Line: line, Key: key, Msg: msg, Fatal: fatal})
+}
+
+// srcLine returns the line in the source file of the key of the deepest value
+// containing offset whose line is known, or 0 if there is none.
+func (c *checker) srcLine(offset int64) int {
+	path := c.root.pathAt(offset)
+	for {
+		if line, ok := c.srcLines[strings.Join(path, pathSep)]; ok {
+			return line
+		}
+		if len(path) == 0 {
+			return 0
+		}
+		path = path[:len(path)-1]
+	}
+}
+
+// addError adds a fatal problem for an error, locating it if possible.
+func (c *checker) addError(err error, key string) {
+	var synErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &synErr):
+		c.add(synErr.Offset, key, synErr.Error(), true)
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			key = typeErr.Field
+		}
+		msg := fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type)
+		c.add(typeErr.Offset, key, msg, true)
+	default:
+		c.add(-1, key, err.Error(), true)
+	}
+}
+
+// checkKeys reports keys not declared by any section, in the configuration or
+// (with a prefix) a channel's overrides.
+func (c *checker) checkKeys(root *jsonNode, secs []*section, prefix string) {
+	if root.children == nil {
+		if prefix == "" {
+			c.add(root.offset, "", "configuration must be a JSON object", true)
+		}
+		return
+	}
+	for _, key := range root.keys {
+		node := root.children[key]
+		path := prefix + key
+		if strings.EqualFold(key, channelConfigKey) {
+			if prefix != "" {
+				c.add(node.offset, path, "channel overrides can't be nested", true)
+				continue
+			}
+			for _, ch := range node.keys {
+				c.checkKeys(node.children[ch], secs, path+"."+ch+".")
+			}
+			continue
+		}
+		found := false
+		for _, sec := range secs {
+			if sec.key != "" && strings.EqualFold(sec.key, key) {
+				c.checkNodeKeys(node, sec.defaults.Type(), path)
+				found = true
+				break
+			}
+			if sec.key == "" {
+				if field, ok := fieldByKey(sec.defaults.Type(), key); ok {
+					c.checkNodeKeys(node, field.Type, path)
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			c.add(node.offset, path, "unknown key", false)
+		}
+	}
+}
+
+// checkNodeKeys reports keys in node that typ doesn't declare.
+func (c *checker) checkNodeKeys(node *jsonNode, typ reflect.Type, path string) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		for _, key := range node.keys {
+			field, ok := fieldByKey(typ, key)
+			if !ok {
+				c.add(node.children[key].offset, path+"."+key, "unknown key", true)
+				continue
+			}
+			c.checkNodeKeys(node.children[key], field.Type, path+"."+key)
+		}
+	case reflect.Map:
+		for _, key := range node.keys {
+			c.checkNodeKeys(node.children[key], typ.Elem(), path+"."+key)
+		}
+	case reflect.Slice, reflect.Array:
+		for i, elem := range node.elems {
+			c.checkNodeKeys(elem, typ.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// checkSection decodes data for a section, and reports type errors and
+// constraint violations.  It returns whether problems were found.
+func (c *checker) checkSection(sec *section, data []byte, root *jsonNode) bool {
+	val := reflect.New(sec.defaults.Type())
+	val.Elem().Set(sec.defaults)
+	target := val.Interface()
+	if sec.key != "" {
+		// wrap the section in a struct, so errors report positions in data
+		wrapType := reflect.StructOf([]reflect.StructField{{
+			Name: "Section",
+			Type: val.Type(),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:"%s"`, sec.key)),
+		}})
+		wrap := reflect.New(wrapType)
+		wrap.Elem().Field(0).Set(val)
+		target = wrap.Interface()
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		c.addError(err, sec.key)
+		return true
+	}
+
+	v, ok := val.Interface().(Validator)
+	if !ok {
+		return false
+	}
+	err := v.Validate()
+	if err == nil {
+		return false
+	}
+	errs := []error{err}
+	if multi, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = multi.Unwrap()
+	}
+	for _, err := range errs {
+		path := []string{}
+		if sec.key != "" {
+			path = append(path, sec.key)
+		}
+		var fieldErr *FieldError
+		if errors.As(err, &fieldErr) {
+			path = append(path, fieldErr.Path...)
+			err = fieldErr.Err
+		}
+		c.add(root.find(path), strings.Join(path, "."), err.Error(), true)
+	}
+	return true
+}
+
+// fieldByKey finds the struct field that JSON decoding would use for key.
+func fieldByKey(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if f, ok := fieldByKey(field.Type, key); ok {
+				return f, true
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// jsonNode is a parsed JSON value, with the positions of object keys.  start
+// and end delimit the value, though start may precede it with separators.
+type jsonNode struct {
+	offset     int64
+	start, end int64
+	keys       []string
+	children   map[string]*jsonNode
+	elems      []*jsonNode
+}
+
+// parseNode parses the next JSON value from dec.
+func parseNode(dec *json.Decoder) (node *jsonNode, err error) {
+	start := dec.InputOffset()
+	defer func() {
+		if node != nil {
+			node.start, node.end = start, dec.InputOffset()
+		}
+	}()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	node = &jsonNode{offset: dec.InputOffset()}
+	switch tok {
+	case json.Delim('{'):
+		node.children = map[string]*jsonNode{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyTok.(string)
+			offset := dec.InputOffset()
+			child, err := parseNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			child.offset = offset
+			node.keys = append(node.keys, key)
+			node.children[key] = child
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for dec.More() {
+			elem, err := parseNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.elems = append(node.elems, elem)
+		}
+		_, err = dec.Token()
+	}
+	return node, err
+}
+
+// maskSecrets returns a copy of data with secrets file references replaced by
+// empty strings, padded to keep the positions of everything else.
+func maskSecrets(data []byte, root *jsonNode) []byte {
+	masked := append([]byte(nil), data...)
+	var walk func(n *jsonNode)
+	walk = func(n *jsonNode) {
+		if _, ok := n.children[secretsKey]; ok && len(n.keys) == 1 {
+			start := n.start + int64(bytes.IndexByte(masked[n.start:n.end], '{'))
+			for i := start; i < n.end; i++ {
+				if masked[i] != '\n' {
+					masked[i] = ' '
+				}
+			}
+			masked[start], masked[start+1] = '"', '"'
+			return
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+		for _, elem := range n.elems {
+			walk(elem)
+		}
+	}
+	walk(root)
+	return masked
+}
+
+// pathAt returns the path of keys, and indexes for array elements, to the
+// deepest node containing offset.
+func (n *jsonNode) pathAt(offset int64) []string {
+	path := []string{}
+	for n != nil {
+		var next *jsonNode
+		for _, k := range n.keys {
+			if child := n.children[k]; child.start <= offset && offset <= child.end {
+				next = child
+				path = append(path, k)
+				break
+			}
+		}
+		for i, elem := range n.elems {
+			if next == nil && elem.start <= offset && offset <= elem.end {
+				next = elem
+				path = append(path, strconv.Itoa(i))
+			}
+		}
+		n = next
+	}
+	return path
+}
+
+// find returns the offset of the deepest node found along a path of keys, or
+// -1 if there is no tree.
+func (n *jsonNode) find(path []string) int64 {
+	if n == nil {
+		return -1
+	}
+	for _, key := range path {
+		next := (*jsonNode)(nil)
+		for _, k := range n.keys {
+			if strings.EqualFold(k, key) {
+				next = n.children[k]
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return n.offset
+}
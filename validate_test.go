@@ -0,0 +1,128 @@
+package bort_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ianremmler/bort"
+)
+
+type testConfig struct {
+	Limit  int
+	Labels map[string]string
+	Secret string
+}
+
+func (c *testConfig) Validate() error {
+	if c.Limit < 0 {
+		return &bort.FieldError{Path: []string{"Limit"}, Err: errors.New("must not be negative")}
+	}
+	return nil
+}
+
+func TestCheckConfig(t *testing.T) {
+	defer bort.Isolate()()
+	bort.RegisterConfig("Test", &testConfig{Limit: 1})
+
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "bort.conf")
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data string
+		want []bort.ConfigProblem
+	}{
+		{`{"Test": {"Limit": 2, "Secret": {"$file": "secret"}}}`, nil},
+		{`{
+			"Other": 1,
+			"Test": {
+				"Limt": 2
+			}
+		}`, []bort.ConfigProblem{
+			{File: cfgFile, Line: 2, Key: "Other", Msg: "unknown key"},
+			{File: cfgFile, Line: 4, Key: "Test.Limt", Msg: "unknown key", Fatal: true},
+		}},
+		{`{
+			"Test": {
+				"Limit": "two"
+			}
+		}`, []bort.ConfigProblem{
+			{File: cfgFile, Line: 3, Key: "Test.Limit", Msg: "cannot use string as int", Fatal: true},
+		}},
+		{`{
+			"Test": {"Limit": -1}
+		}`, []bort.ConfigProblem{
+			{File: cfgFile, Line: 2, Key: "Test.Limit", Msg: "must not be negative", Fatal: true},
+		}},
+		{`{"Test": }`, []bort.ConfigProblem{
+			{File: cfgFile, Line: 1, Msg: "missing value after object key", Fatal: true},
+		}},
+	}
+	for i, test := range tests {
+		if err := os.WriteFile(cfgFile, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bort.CheckConfig(cfgFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%d: got %v, want %v", i, got, test.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != test.want[j] {
+				t.Errorf("%d: got %v, want %v", i, got[j], test.want[j])
+			}
+		}
+	}
+
+	// YAML problems are located by their keys, and TOML ones aren't located
+	yamlFile, tomlFile := filepath.Join(dir, "bort.yaml"), filepath.Join(dir, "bort.toml")
+	files := map[string]string{
+		yamlFile: "Other: 1\nTest:\n  Labels:\n    a: b\n  Limt: 2\n  Limit: two\n",
+		tomlFile: "[Test]\nLimt = 2\n",
+	}
+	for file, data := range files {
+		if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wants := map[string][]bort.ConfigProblem{
+		yamlFile: {
+			{File: yamlFile, Line: 1, Key: "Other", Msg: "unknown key"},
+			{File: yamlFile, Line: 5, Key: "Test.Limt", Msg: "unknown key", Fatal: true},
+			{File: yamlFile, Line: 6, Key: "Test.Limit", Msg: "cannot use string as int", Fatal: true},
+		},
+		tomlFile: {{File: tomlFile, Key: "Test.Limt", Msg: "unknown key", Fatal: true}},
+	}
+	for file, want := range wants {
+		got, err := bort.CheckConfig(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v, want %v", file, got, want)
+			continue
+		}
+		for j := range got {
+			if got[j] != want[j] {
+				t.Errorf("%s: got %v, want %v", file, got[j], want[j])
+			}
+		}
+	}
+
+	t.Setenv("BORT_TEST_LIMT", "2")
+	if err := os.WriteFile(cfgFile, []byte(`{"Test": {"Limit": 2}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bort.CheckConfig(cfgFile)
+	want := bort.ConfigProblem{File: cfgFile, Key: "BORT_TEST_LIMT",
+		Msg: "environment variable ignored, as it names an undeclared key"}
+	if err != nil || len(got) != 1 || got[0] != want {
+		t.Errorf("ignored variable: got %v, %v", got, err)
+	}
+}